@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"sync/atomic"
+)
+
+// adminToken protects the admin endpoints, admin endpoints are disabled if empty
+var adminToken string
+
+// paused is 1 if webhook processing is paused
+var paused int32
+
+// ErrPaused is returned by webhooks while processing is paused
+var ErrPaused = fiber.NewError(fiber.StatusServiceUnavailable, "paused")
+
+func isPaused() bool {
+	return atomic.LoadInt32(&paused) == 1
+}
+
+// adminOnly is a middleware which rejects requests without a valid admin token
+func adminOnly(ctx *fiber.Ctx) error {
+	if adminToken == "" {
+		return fiber.ErrNotFound
+	}
+	token := strings.TrimPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid admin token")
+	}
+	return ctx.Next()
+}
+
+// registerAdminRoutes registers all admin endpoints on r.
+// The underscore keeps /_admin out of the names of webhooks (/:name/:secret)
+func registerAdminRoutes(r fiber.Router) {
+	r.Get("/updates", adminOnly, handleUpdates)
+	r.Get("/status", adminOnly, handleStatus)
+	admin := r.Group("/_admin")
+	admin.Get("/webhooks", adminOnly, handleWebhooks)
+	admin.Get("/history/:name", adminOnly, handleHistory)
+	admin.Post("/approve/:id", adminOnly, handleApprove)
+	admin.Post("/pause", adminOnly, func(ctx *fiber.Ctx) error {
+		atomic.StoreInt32(&paused, 1)
+		log.Warn("Webhook processing paused")
+		return ctx.JSON(fiber.Map{"paused": true})
+	})
+	admin.Post("/resume", adminOnly, func(ctx *fiber.Ctx) error {
+		atomic.StoreInt32(&paused, 0)
+		log.Info("Webhook processing resumed")
+		return ctx.JSON(fiber.Map{"paused": false})
+	})
+}
+
+// status contains the state of yadwh
+type status struct {
+	Paused   bool           `json:"paused"`
+	Webhooks int            `json:"webhooks"`
+	Records  map[string]int `json:"records"` // amount of records kept in memory
+
+	Locks  locksUsage `json:"locks"`
+	LastGC *gcResult  `json:"last_gc,omitempty"`
+
+	Approvals []*pendingApproval `json:"approvals"`
+	Audits    []*auditResult     `json:"audits,omitempty"` // last audit of each webhook in audit mode
+}
+
+// handleStatus returns the state of yadwh
+func handleStatus(ctx *fiber.Ctx) error {
+	return ctx.JSON(status{
+		Paused:   isPaused(),
+		Webhooks: len(attrs),
+		Records:  recordCounts(),
+
+		Locks:  locksUsage{Containers: containerLocks.stats(), Images: imageLocks.stats()},
+		LastGC: lastGCResult(),
+
+		Approvals: pendingApprovals(),
+		Audits:    lastAudits(),
+	})
+}
+
+// availableUpdate is a container whose image has a newer version in its registry
+type availableUpdate struct {
+	ID       string   `json:"id"`
+	Names    []string `json:"names"`
+	Image    string   `json:"image"`
+	Webhooks []string `json:"webhooks"`
+	Digest   string   `json:"digest"` // digest of the newer image
+}
+
+// handleUpdates lists all labeled containers with newer images in their registry
+func handleUpdates(ctx *fiber.Ctx) (err error) {
+	var containerList []types.Container
+	if containerList, err = dc.ContainerList(ctx.Context(), types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelKey)),
+	}); err != nil {
+		return fiber.NewError(500, err.Error())
+	}
+
+	updates := make([]availableUpdate, 0)
+	for _, cont := range containerList {
+		webhooks := watchedBy(&cont)
+
+		var digest string
+		if digest, err = remoteDigest(ctx.Context(), cont.Image, registryAuth(cont.Image, authFor(webhooks))); err != nil {
+			log.WithError(err).Warnf("Cannot check registry for %s", cont.Image)
+			continue
+		}
+		var current bool
+		if current, err = hasDigest(ctx.Context(), cont.ImageID, digest); err != nil {
+			log.WithError(err).Warnf("Cannot inspect image %s", trimID(cont.ImageID))
+			continue
+		}
+		if current {
+			continue
+		}
+		updates = append(updates, availableUpdate{
+			ID:       cont.ID,
+			Names:    cont.Names,
+			Image:    cont.Image,
+			Webhooks: webhooks,
+			Digest:   digest,
+		})
+	}
+	return ctx.JSON(updates)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"strings"
+)
+
+// allowedNets contains the networks webhooks may be triggered from, all clients are allowed if empty
+var allowedNets []*net.IPNet
+
+// parseCIDRs parses a comma-separated list of CIDR blocks, bare IPs are treated as single hosts
+func parseCIDRs(v string) (nets []*net.IPNet, err error) {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %s", s)
+			}
+			if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		var n *net.IPNet
+		if _, n, err = net.ParseCIDR(s); err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+// ipAllowed checks if ip is contained in one of nets
+func ipAllowed(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustProxies reads the client IP from X-Forwarded-For, only if the request was sent by one of proxies
+func trustProxies(cfg *fiber.Config, proxies []string) {
+	if len(proxies) == 0 {
+		return
+	}
+	cfg.EnableTrustedProxyCheck = true
+	cfg.TrustedProxies = proxies
+	cfg.ProxyHeader = fiber.HeaderXForwardedFor
+	cfg.EnableIPValidation = true
+}
+
+// allowlist is a middleware which rejects clients outside of allowedNets.
+// The client IP is read from X-Forwarded-For if the request came from a trusted proxy
+func allowlist(ctx *fiber.Ctx) error {
+	if len(allowedNets) == 0 {
+		return ctx.Next()
+	}
+	if ip := ctx.IP(); !ipAllowed(allowedNets, net.ParseIP(ip)) {
+		log.WithField("ip", ip).Warn("Rejected request from disallowed IP")
+		return fiber.NewError(fiber.StatusForbidden, "ip not allowed")
+	}
+	return ctx.Next()
+}
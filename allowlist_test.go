@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		invalid bool
+	}{
+		{in: "10.0.0.0/8", want: []string{"10.0.0.0/8"}},
+		{in: "192.168.1.5", want: []string{"192.168.1.5/32"}},
+		{in: "2001:db8::1", want: []string{"2001:db8::1/128"}},
+		{in: " 10.0.0.0/8 , fd00::/8,", want: []string{"10.0.0.0/8", "fd00::/8"}},
+		{in: "", want: nil},
+		{in: "10.0.0.0/33", invalid: true},
+		{in: "not-an-ip", invalid: true},
+	}
+	for _, tt := range tests {
+		nets, err := parseCIDRs(tt.in)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("parseCIDRs(%q): expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCIDRs(%q): %v", tt.in, err)
+			continue
+		}
+		if len(nets) != len(tt.want) {
+			t.Errorf("parseCIDRs(%q) = %v, want %v", tt.in, nets, tt.want)
+			continue
+		}
+		for i, n := range nets {
+			if n.String() != tt.want[i] {
+				t.Errorf("parseCIDRs(%q)[%d] = %s, want %s", tt.in, i, n, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	nets, err := parseCIDRs("10.0.0.0/8, 192.168.1.5, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"2001:db8::42", true},
+		{"2001:db9::1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ipAllowed(nets, net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("ipAllowed(%q) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	prev := allowedNets
+	t.Cleanup(func() { allowedNets = prev })
+	var err error
+	if allowedNets, err = parseCIDRs("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+
+	// requests of app.Test come from 0.0.0.0
+	tests := []struct {
+		name    string
+		proxies []string
+		xff     string
+		status  int
+	}{
+		{"direct client outside", nil, "", fiber.StatusForbidden},
+		{"header without trusted proxies", nil, "10.1.2.3", fiber.StatusForbidden},
+		{"trusted proxy, client inside", []string{"0.0.0.0"}, "10.1.2.3", fiber.StatusOK},
+		{"trusted proxy, client outside", []string{"0.0.0.0"}, "11.0.0.1", fiber.StatusForbidden},
+		{"untrusted proxy", []string{"192.168.0.1"}, "10.1.2.3", fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg fiber.Config
+			trustProxies(&cfg, tt.proxies)
+			app := fiber.New(cfg)
+			app.Use(allowlist)
+			app.Get("/", func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(fiber.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.xff != "" {
+				req.Header.Set(fiber.HeaderXForwardedFor, tt.xff)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
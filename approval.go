@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/apex/log"
+	"github.com/gofiber/fiber/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// localApproved is the fiber local containing the IDs of containers whose update was approved
+const localApproved = "yadwh-approved"
+
+// approvalTimeout is the time after which unapproved updates expire
+var approvalTimeout = time.Hour
+
+// pendingApproval is an update waiting for approval by an admin
+type pendingApproval struct {
+	ID         string    `json:"id"`
+	Webhook    string    `json:"webhook"`
+	Containers []string  `json:"containers"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+}
+
+var (
+	approvals   = make(map[string]*pendingApproval)
+	approvalsMu sync.Mutex
+)
+
+// randomID returns a random hex encoded ID of n bytes
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestApproval creates a pending approval for updating containers of a webhook
+func requestApproval(webhook string, containers []string) *pendingApproval {
+	now := time.Now()
+	a := &pendingApproval{
+		ID:         randomID(8),
+		Webhook:    webhook,
+		Containers: containers,
+		Created:    now,
+		Expires:    now.Add(approvalTimeout),
+	}
+	approvalsMu.Lock()
+	approvals[a.ID] = a
+	approvalsMu.Unlock()
+	log.Infof("Update of %d container(s) of %s requires approval (id %s)", len(containers), webhook, a.ID)
+	return a
+}
+
+// takeApproval removes and returns a pending approval if it's not expired
+func takeApproval(id string) (*pendingApproval, bool) {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	a, ok := approvals[id]
+	if !ok {
+		return nil, false
+	}
+	delete(approvals, id)
+	return a, time.Now().Before(a.Expires)
+}
+
+// pendingApprovals returns all pending approvals sorted by creation
+func pendingApprovals() []*pendingApproval {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	res := make([]*pendingApproval, 0, len(approvals))
+	for _, a := range approvals {
+		res = append(res, a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.Before(res[j].Created)
+	})
+	return res
+}
+
+// sweepApprovals purges expired approvals
+func sweepApprovals(now time.Time) int {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+	for id, a := range approvals {
+		if now.After(a.Expires) {
+			log.Infof("Approval %s of %s expired", id, a.Webhook)
+			delete(approvals, id)
+		}
+	}
+	return len(approvals)
+}
+
+// handleApprove runs an update waiting for approval
+func handleApprove(ctx *fiber.Ctx) error {
+	a, ok := takeApproval(ctx.Params("id"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "approval not found or expired")
+	}
+	if _, ok = attrs[a.Webhook]; !ok {
+		return ErrWebhookNotFound
+	}
+	log.Infof("Update of %s was approved (id %s)", a.Webhook, a.ID)
+	approved := make(map[string]bool, len(a.Containers))
+	for _, id := range a.Containers {
+		approved[id] = true
+	}
+	ctx.Locals(localApproved, approved)
+	// the admin token replaces the authentication of the webhook
+	return process(a.Webhook, "", ctx)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// actions of audited containers in the history
+const (
+	ActionDrifted = "drifted"
+	ActionCurrent = "current"
+)
+
+// FailAuditCheck is the reason of audited containers whose image couldn't be compared with its registry
+const FailAuditCheck = "check-failed"
+
+// auditedContainer is a container checked for drift by an audit
+type auditedContainer struct {
+	ID      string   `json:"id"`
+	Names   []string `json:"names"`
+	Image   string   `json:"image"`
+	ImageID string   `json:"image_id"`
+	// Digest is the digest of the image in its registry
+	Digest string `json:"digest,omitempty"`
+	// Drift is true if the container doesn't run the image of its registry
+	Drift bool   `json:"drift"`
+	Error string `json:"error,omitempty"`
+}
+
+// auditResult is the result of a trigger of a webhook in audit mode
+type auditResult struct {
+	Webhook    string             `json:"webhook"`
+	At         time.Time          `json:"at"`
+	Drifted    int                `json:"drifted"`
+	Containers []auditedContainer `json:"containers"`
+}
+
+var (
+	audits   = make(map[string]*auditResult) // last audit of each webhook
+	auditsMu sync.Mutex
+)
+
+// audit compares the images of the containers matched by the webhook name with their registry
+// without changing anything. The result is added to the history of the webhook
+func audit(name, requestID string, a *attributes, ctx *fiber.Ctx) (err error) {
+	// the result outlives the request
+	name = utils.CopyString(name)
+	var containerList []types.Container
+	if containerList, err = matchingContainers(ctx.Context(), name); err != nil {
+		return fiber.NewError(500, err.Error())
+	}
+
+	res := &auditResult{Webhook: name, At: time.Now(), Containers: []auditedContainer{}}
+	for _, cont := range containerList {
+		c := auditedContainer{
+			ID:      cont.ID,
+			Names:   cont.Names,
+			Image:   cont.Image,
+			ImageID: cont.ImageID,
+		}
+		var current bool
+		if c.Digest, err = remoteDigest(ctx.Context(), cont.Image, a.imageAuth(ctx.Context(), cont.Image)); err != nil {
+			log.WithError(err).Warnf("Cannot check registry for %s", cont.Image)
+			c.Error = err.Error()
+		} else if current, err = hasDigest(ctx.Context(), cont.ImageID, c.Digest); err != nil {
+			log.WithError(err).Warnf("Cannot inspect image %s", trimID(cont.ImageID))
+			c.Error = err.Error()
+		} else if !current {
+			log.Infof("Container %s drifted from %s", trimID(cont.ID), cont.Image)
+			c.Drift = true
+			res.Drifted++
+		}
+		res.Containers = append(res.Containers, c)
+	}
+
+	auditsMu.Lock()
+	audits[name] = res
+	auditsMu.Unlock()
+	recordAudit(name, requestID, res)
+	return ctx.JSON(res)
+}
+
+// lastAudits returns the last audit of each webhook in audit mode
+func lastAudits() (res []*auditResult) {
+	auditsMu.Lock()
+	defer auditsMu.Unlock()
+	for _, r := range audits {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Webhook < res[j].Webhook
+	})
+	return
+}
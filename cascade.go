@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"github.com/apex/log"
+	"strings"
+)
+
+// LabelTriggers contains the names of containers to restart after the container was updated
+const LabelTriggers = "io.d2a.yadwh.triggers"
+
+// cascadedContainer is a container restarted because a container it depends on was updated
+type cascadedContainer struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	TriggeredBy string `json:"triggered_by"`
+	Error       string `json:"error,omitempty"`
+}
+
+// triggersOf returns the container names in the triggers label
+func triggersOf(labels map[string]string) (names []string) {
+	for _, n := range strings.Split(labels[LabelTriggers], ",") {
+		if n = strings.TrimPrefix(strings.TrimSpace(n), "/"); n != "" {
+			names = append(names, n)
+		}
+	}
+	return
+}
+
+// cascade restarts the containers triggered by the updated containers and, transitively, the containers triggered by them.
+// Every container is restarted at most once, updated containers are never restarted
+func cascade(dctx context.Context, restarted []restartedContainer, holder string) (cascaded []cascadedContainer) {
+	type trigger struct{ name, by string }
+	var (
+		visited = make(map[string]bool)
+		queue   []trigger
+	)
+	for _, r := range restarted {
+		by := strings.TrimPrefix(containerKey(r.Names, r.ID), "/")
+		visited[by] = true
+		for _, n := range triggersOf(r.Labels) {
+			queue = append(queue, trigger{n, by})
+		}
+	}
+	for len(queue) > 0 && dctx.Err() == nil {
+		t := queue[0]
+		queue = queue[1:]
+		if visited[t.name] {
+			continue
+		}
+		visited[t.name] = true
+
+		c := cascadedContainer{Name: t.name, TriggeredBy: t.by}
+		inspect, err := dc.ContainerInspect(dctx, t.name)
+		if err != nil {
+			log.WithError(err).Warnf("Cannot find container %s triggered by %s", t.name, t.by)
+			c.Error = err.Error()
+			cascaded = append(cascaded, c)
+			continue
+		}
+		c.ID = inspect.ID
+		// only containers managed by yadwh may be restarted
+		if _, ok := inspect.Config.Labels[LabelKey]; !ok {
+			log.Warnf("Container %s triggered by %s is not labeled with %s, skipping", t.name, t.by, LabelKey)
+			c.Error = "container is not labeled with " + LabelKey
+			cascaded = append(cascaded, c)
+			continue
+		}
+
+		log.Infof("Restarting container %s triggered by %s", t.name, t.by)
+		unlock, err := lockContainer(dctx, inspect.Name, holder)
+		if err != nil {
+			c.Error = err.Error()
+			cascaded = append(cascaded, c)
+			continue
+		}
+		timeout := stopTimeout(&inspect, -1)
+		err = dc.ContainerRestart(dctx, inspect.ID, &timeout)
+		unlock()
+		if err != nil {
+			log.WithError(err).Warnf("Cannot restart container %s", t.name)
+			c.Error = err.Error()
+			cascaded = append(cascaded, c)
+			continue
+		}
+		cascaded = append(cascaded, c)
+		for _, n := range triggersOf(inspect.Config.Labels) {
+			queue = append(queue, trigger{n, t.name})
+		}
+	}
+	return
+}
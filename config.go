@@ -0,0 +1,544 @@
+package main
+
+import (
+	"fmt"
+	"github.com/antonmedv/expr/vm"
+	"github.com/apex/log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getEnv returns the trimmed value of the per-webhook environment variable prefix+name
+func getEnv(prefix, name string) string {
+	return strings.TrimSpace(os.Getenv(prefix + name))
+}
+
+// sources of the secret of the /:name route
+const (
+	SecretSourceQuery  = "query"
+	SecretSourceHeader = "header"
+	SecretSourceBody   = "body"
+)
+
+// secretSources contains the sources of the secret of the /:name route in the order they are checked
+var secretSources = []string{SecretSourceQuery, SecretSourceHeader, SecretSourceBody}
+
+// parseSecretSources parses a comma separated list of secret sources
+func parseSecretSources(v string) (sources []string, err error) {
+	seen := make(map[string]bool)
+	for _, source := range strings.Split(v, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		switch source {
+		case SecretSourceQuery, SecretSourceHeader, SecretSourceBody:
+		default:
+			return nil, fmt.Errorf("unknown secret source: %q", source)
+		}
+		if !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+	return
+}
+
+// source returns the per-webhook setting prefix of the webhook name, e.g. getEnv
+type source func(prefix, name string) string
+
+// getDuration parses the per-webhook setting prefix of name as non-negative duration.
+// It returns 0 if the setting is not set
+func getDuration(get source, prefix, name string) (d time.Duration, err error) {
+	v := get(prefix, name)
+	if v == "" {
+		return 0, nil
+	}
+	if d, err = time.ParseDuration(v); err == nil && d < 0 {
+		err = fmt.Errorf("negative duration: %s", v)
+	}
+	return
+}
+
+// parseSelector parses a comma separated list of label selectors (key or key=value)
+func parseSelector(v string) (selector []string, err error) {
+	for _, sel := range strings.Split(v, ",") {
+		if sel = strings.TrimSpace(sel); sel == "" {
+			continue
+		}
+		key := sel
+		if i := strings.Index(sel, "="); i >= 0 {
+			key = sel[:i]
+		}
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid label selector: %q", sel)
+		}
+		selector = append(selector, sel)
+	}
+	return
+}
+
+// loadRetryPolicy reads the retry settings of outgoing notifications from the environment
+func loadRetryPolicy() {
+	if v := strings.TrimSpace(os.Getenv(EnvRetryAttempts)); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			log.Warnf("Invalid %s: %s", EnvRetryAttempts, v)
+		} else {
+			notifyRetry.attempts = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvRetryMaxDelay)); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			log.Warnf("Invalid %s: %s", EnvRetryMaxDelay, v)
+		} else {
+			notifyRetry.max = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvNotifyTimeout)); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			log.Warnf("Invalid %s: %s", EnvNotifyTimeout, v)
+		} else {
+			notifyTimeout = d
+		}
+	}
+}
+
+// loadWebhooks reads all webhooks and their settings from the environment into attrs
+func loadWebhooks() {
+	seen := make(map[string]bool)
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, EnvSecretPrefix) {
+			continue
+		}
+		key := env[:strings.Index(env, "=")]
+		name := key[len(EnvSecretPrefix):]
+		if strings.HasPrefix(key, EnvSecretFilePrefix) {
+			name = key[len(EnvSecretFilePrefix):]
+		}
+		if len(name) == 0 {
+			log.Warnf("Empty secret name: %s", env)
+			continue
+		}
+		// the secret may be set by value and by file
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if a := parseWebhook(name, getEnv); a != nil {
+			attrs[name] = a
+			log.Infof("Loaded webhook %s from the environment", name)
+		}
+	}
+}
+
+// getSecret returns the value of the per-webhook setting prefix+name
+// or the trimmed content of the file set by filePrefix+name
+func getSecret(get source, prefix, filePrefix, name string) (string, error) {
+	value, path := get(prefix, name), get(filePrefix, name)
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s are both set", prefix+name, filePrefix+name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Loaded %s from file %s", prefix+name, path)
+	return strings.TrimSpace(string(data)), nil
+}
+
+// parseWebhook reads and validates the settings of the webhook name from get.
+// It returns nil if a setting is invalid
+func parseWebhook(name string, get source) *attributes {
+	// find secret
+	sec, err := getSecret(get, EnvSecretPrefix, EnvSecretFilePrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Cannot read secret")
+		return nil
+	}
+	if len(sec) < 12 {
+		log.WithField("webhook", name).Warn("Secrets are required to be at least 12 chars long")
+		return nil
+	}
+	log.Infof("Found secret for %s = %s", name, strings.Repeat("*", len(sec)))
+
+	// find auth in env
+	auth, err := getSecret(get, EnvAuthPrefix, EnvAuthFilePrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Cannot read registry auth")
+		return nil
+	}
+	log.Infof("auth secret for %s = %s", name, strings.Repeat("*", len(auth)))
+
+	// find abort on authentication failures
+	authFailFast := get(EnvAuthFailFastPrefix, name) == "true"
+
+	// find ECR tokens
+	ecr := get(EnvECRPrefix, name) == "true"
+
+	// find remove old images
+	removeOld := get(EnvRemovePrefix, name) == "true"
+	if removeOld { // display warning if purge mode is enabled
+		log.Warnf("Purge-Mode was enabled for %s:", name)
+		log.Warn("Old images will be deleted after downloading new images.")
+	}
+
+	// find pruning of dangling images after updates
+	prune := get(EnvPrunePrefix, name) == "true"
+	if prune && removeOld {
+		log.WithField("webhook", name).Warnf("%s is ignored, images are pruned by %s", EnvRemovePrefix+name, EnvPrunePrefix+name)
+	}
+
+	// find max duration of an update
+	maxDuration, err := getDuration(get, EnvMaxDurPrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid max duration")
+		return nil
+	}
+	if maxDuration > 0 {
+		log.Infof("Updates for %s are limited to %s", name, maxDuration)
+	}
+
+	// find all or nothing updates
+	atomic := get(EnvAtomicPrefix, name) == "true"
+	if atomic && removeOld {
+		log.WithField("webhook", name).Warnf("%s is ignored, old images are kept to roll back to in atomic mode", EnvRemovePrefix+name)
+	}
+
+	// find delay between removing and creating a container
+	swapDelay, err := getDuration(get, EnvSwapDelayPrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid swap delay")
+		return nil
+	}
+
+	// find zero-downtime replacement
+	zeroDowntime := get(EnvZeroDowntimePrefix, name) == "true"
+
+	// find stop timeout of containers
+	stopTimeout := time.Duration(-1)
+	if v := get(EnvStopTimeoutPrefix, name); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			log.WithField("webhook", name).Warnf("Invalid stop timeout (seconds): %s", v)
+			return nil
+		}
+		stopTimeout = time.Duration(seconds) * time.Second
+		log.Infof("Containers of %s have %s to stop", name, stopTimeout)
+	}
+
+	// find allowed resource changes
+	allowResources := make(map[string]bool)
+	for _, r := range strings.Split(get(EnvAllowResPrefix, name), ",") {
+		switch r = strings.ToLower(strings.TrimSpace(r)); r {
+		case "":
+		case "memory", "cpus":
+			allowResources[r] = true
+		default:
+			log.WithField("webhook", name).Warnf("Unknown resource: %s", r)
+		}
+	}
+	if len(allowResources) > 0 {
+		log.Infof("Resource limits of %s may be changed by requests", name)
+	}
+
+	// find allowed host ports
+	allowPorts, err := parsePortRanges(get(EnvAllowPortsPrefix, name))
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid allowed ports")
+		return nil
+	}
+	if len(allowPorts) > 0 {
+		log.Infof("Published ports of %s may be changed by requests to %s", name, get(EnvAllowPortsPrefix, name))
+	}
+
+	// find handling of restarting containers
+	restarting := strings.ToLower(get(EnvRestartingPrefix, name))
+	switch restarting {
+	case "":
+		restarting = RestartingPolicy
+	case RestartingPolicy, RestartingKill, RestartingSkip:
+	default:
+		log.WithField("webhook", name).Warnf("Invalid handling of restarting containers: %s", restarting)
+		return nil
+	}
+
+	// find deploy stamp
+	stamp := get(EnvStampPrefix, name) == "true"
+
+	// find resume of partially failed updates
+	resume := get(EnvResumePrefix, name) == "true"
+	if resume && !stamp {
+		log.WithField("webhook", name).Warnf("%s requires %s", EnvResumePrefix+name, EnvStampPrefix+name)
+	}
+
+	// find re-creation of up to date containers
+	force := get(EnvForcePrefix, name) == "true"
+
+	// find cosign public key
+	cosignKey := get(EnvCosignKeyPrefix, name)
+	if cosignKey != "" {
+		if _, err := os.Stat(cosignKey); err != nil {
+			log.WithField("webhook", name).WithError(err).Warn("Cannot find cosign key")
+			return nil
+		}
+		if _, err := exec.LookPath(cosignBinary); err != nil {
+			log.WithField("webhook", name).WithError(err).Warn("Cannot find cosign to verify signatures")
+			return nil
+		}
+		log.Infof("Signatures of images for %s are verified with %s", name, cosignKey)
+	}
+
+	// find adoption of image defaults
+	adoptDefaults := get(EnvAdoptPrefix, name) == "true"
+
+	// find platform
+	platform := strings.ToLower(strings.TrimSpace(get(EnvPlatformPrefix, name)))
+	if platform != "" && !validPlatform.MatchString(platform) {
+		log.WithField("webhook", name).Warnf("Invalid platform: %s (expected os/arch[/variant])", platform)
+		return nil
+	}
+
+	// find label selector
+	selector, err := parseSelector(get(EnvSelectorPrefix, name))
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid selector")
+		return nil
+	}
+	if len(selector) > 0 {
+		log.Infof("Containers of %s must match %s", name, strings.Join(selector, ","))
+	}
+
+	// find allowed HTTP methods
+	var methods []string
+	for _, m := range strings.Split(get(EnvMethodsPrefix, name), ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			methods = append(methods, m)
+		}
+	}
+
+	// find containers named explicitly
+	var containers []string
+	for _, c := range strings.Split(get(EnvContainersPrefix, name), ",") {
+		if c = strings.TrimPrefix(strings.TrimSpace(c), "/"); c != "" {
+			containers = append(containers, c)
+		}
+	}
+	if len(containers) > 0 {
+		log.Infof("%s also updates the containers %s", name, strings.Join(containers, ", "))
+	}
+
+	// find match expression
+	matchExpr := get(EnvMatchExprPrefix, name)
+	var match *vm.Program
+	if matchExpr != "" {
+		if match, err = compileMatch(matchExpr); err != nil {
+			log.WithField("webhook", name).WithError(err).Warn("Invalid match expression")
+			return nil
+		}
+		log.Infof("Containers of %s must match %s", name, matchExpr)
+	}
+
+	// find conflict mode
+	conflictMode := strings.ToLower(get(EnvConflictPrefix, name))
+	switch conflictMode {
+	case "":
+		conflictMode = ConflictQueue
+	case ConflictQueue, ConflictReject:
+	default:
+		log.WithField("webhook", name).Warnf("Invalid conflict mode: %s", conflictMode)
+		return nil
+	}
+
+	// find mode
+	mode := strings.ToLower(get(EnvModePrefix, name))
+	switch mode {
+	case "":
+		mode = ModeUpdate
+	case ModeUpdate, ModePullOnly, ModeRestartOnly:
+	default:
+		log.WithField("webhook", name).Warnf("Invalid mode: %s", mode)
+		return nil
+	}
+
+	// find maximum wait for a running update
+	queueTimeout, err := getDuration(get, EnvQueueTimeoutPrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid queue timeout")
+		return nil
+	}
+
+	// find health wait
+	healthTimeout, err := getDuration(get, EnvHealthTimeoutPrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid health timeout")
+		return nil
+	}
+	healthInterval, err := getDuration(get, EnvHealthIntervalPrefix, name)
+	if err != nil || (healthInterval == 0 && get(EnvHealthIntervalPrefix, name) != "") {
+		log.WithField("webhook", name).Warnf("Invalid health interval: %s", get(EnvHealthIntervalPrefix, name))
+		return nil
+	}
+	if healthInterval == 0 {
+		healthInterval = defaultHealthInterval
+	}
+	healthBackoff := 1.0
+	if v := get(EnvHealthBackoffPrefix, name); v != "" {
+		if healthBackoff, err = strconv.ParseFloat(v, 64); err != nil || healthBackoff < 1 {
+			log.WithField("webhook", name).Warnf("Invalid health backoff: %s", v)
+			return nil
+		}
+	}
+	if healthTimeout > 0 {
+		log.Infof("Waiting up to %s for containers of %s to become healthy (interval %s, backoff %.1f)",
+			healthTimeout, name, healthInterval, healthBackoff)
+	}
+	healthRollback := get(EnvHealthRollbackPrefix, name) == "true"
+	if healthRollback && healthTimeout == 0 {
+		log.WithField("webhook", name).Warnf("%s requires %s", EnvHealthRollbackPrefix+name, EnvHealthTimeoutPrefix+name)
+	}
+
+	// find stabilization period
+	stabilization, err := getDuration(get, EnvStabilizePrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid stabilization period")
+		return nil
+	}
+
+	// find watched config
+	watchPath := get(EnvWatchPathPrefix, name)
+	if watchPath != "" {
+		if _, err := os.Stat(watchPath); err != nil {
+			log.WithField("webhook", name).WithError(err).Warn("Cannot find watched path")
+			return nil
+		}
+		log.Infof("Containers of %s are only re-created if %s or their image changed", name, watchPath)
+	}
+
+	// find signature mode
+	signature := strings.ToLower(get(EnvSignaturePrefix, name))
+	switch signature {
+	case "":
+	case SignatureSHA256:
+		log.Infof("%s only accepts requests signed with %s", name, HeaderSignature256)
+	default:
+		log.WithField("webhook", name).Warnf("Invalid signature mode: %s", signature)
+		return nil
+	}
+
+	// find client certificate identities
+	var mtls []string
+	for _, id := range strings.Split(get(EnvMTLSPrefix, name), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			mtls = append(mtls, id)
+		}
+	}
+	if len(mtls) > 0 {
+		if strings.TrimSpace(os.Getenv(EnvTLSClientCA)) == "" {
+			log.WithField("webhook", name).Warnf("%s is required to authorize by client certificate", EnvTLSClientCA)
+		}
+		log.Infof("%s may also be triggered by client certificates of %s", name, strings.Join(mtls, ", "))
+	}
+
+	// find audit mode
+	audit := get(EnvAuditPrefix, name) == "true"
+	if audit {
+		log.Infof("%s is in audit mode and won't update containers", name)
+	}
+
+	// find background jobs
+	async := get(EnvAsyncPrefix, name) == "true"
+
+	// find polling for new images
+	pollInterval, err := getDuration(get, EnvPollIntervalPrefix, name)
+	if err != nil {
+		log.WithField("webhook", name).WithError(err).Warn("Invalid poll interval")
+		return nil
+	}
+	if pollInterval > 0 && audit {
+		log.WithField("webhook", name).Warnf("%s is ignored in audit mode", EnvPollIntervalPrefix+name)
+		pollInterval = 0
+	}
+
+	// find dry-run
+	dryRun := get(EnvDryRunPrefix, name) == "true"
+
+	// find slack notifications
+	slackURL := strings.TrimSpace(get(EnvSlackPrefix, name))
+	if slackURL != "" && !isHTTPURL(slackURL) {
+		log.WithField("webhook", name).Warnf("Invalid Slack webhook URL: %s", slackURL)
+		return nil
+	}
+
+	// find notifications of update results
+	notifyURL := strings.TrimSpace(get(EnvNotifyURLPrefix, name))
+	if notifyURL != "" && !isHTTPURL(notifyURL) {
+		log.WithField("webhook", name).Warnf("Invalid notify URL: %s", notifyURL)
+		return nil
+	}
+
+	// find restart of dependent containers
+	cascade := get(EnvCascadePrefix, name) == "true"
+
+	// find parallel updates
+	concurrency := 1
+	if v := strings.TrimSpace(get(EnvConcurrencyPrefix, name)); v != "" {
+		if concurrency, err = strconv.Atoi(v); err != nil || concurrency < 1 {
+			log.WithField("webhook", name).Warnf("Invalid concurrency: %s", v)
+			return nil
+		}
+	}
+
+	return &attributes{
+		secret:       sec,
+		auth:         auth,
+		authFailFast: authFailFast,
+		ecr:          ecr,
+		removeOld:    removeOld,
+		prune:        prune,
+		maxDuration:  maxDuration,
+		atomic:       atomic,
+		swapDelay:    swapDelay,
+		stopTimeout:  stopTimeout,
+		zeroDowntime: zeroDowntime,
+		concurrency:  concurrency,
+
+		healthTimeout:  healthTimeout,
+		healthInterval: healthInterval,
+		healthBackoff:  healthBackoff,
+		healthRollback: healthRollback,
+		stabilization:  stabilization,
+
+		allowResources: allowResources,
+		allowPorts:     allowPorts,
+		restarting:     restarting,
+		stamp:          stamp,
+		resume:         resume,
+		force:          force,
+		cosignKey:      cosignKey,
+		adoptDefaults:  adoptDefaults,
+		platform:       platform,
+		selector:       selector,
+		containers:     containers,
+		methods:        methods,
+		matchExpr:      matchExpr,
+		match:          match,
+		conflictMode:   conflictMode,
+		mode:           mode,
+		queueTimeout:   queueTimeout,
+		async:          async,
+		pollInterval:   pollInterval,
+		watchPath:      watchPath,
+		mtls:           mtls,
+		signature:      signature,
+		cascade:        cascade,
+		audit:          audit,
+		dryRun:         dryRun,
+		slackURL:       slackURL,
+		notifyURL:      notifyURL,
+
+		lock: newUpdateLock(),
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLoadWebhooks(t *testing.T) {
+	// other webhooks of the environment must not be loaded
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, EnvSecretPrefix) {
+			key := env[:strings.Index(env, "=")]
+			t.Setenv(key, "")
+			_ = os.Unsetenv(key)
+		}
+	}
+	prev := attrs
+	attrs = make(map[string]*attributes)
+	t.Cleanup(func() { attrs = prev })
+
+	// the global secret sources are a guessable secret and must not be loaded as webhook
+	t.Setenv(EnvSecretSources, "query,header,body")
+	t.Setenv(EnvSecretPrefix+"WEB", "s3cr3t-s3cr3t")
+	loadWebhooks()
+
+	var names []string
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"WEB"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected webhooks %v, got %v", want, names)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/moby/moby/client"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handling of containers caught in a restart loop
+const (
+	RestartingPolicy = "policy" // disable the restart policy before stopping
+	RestartingKill   = "kill"   // kill the container instead of stopping it
+	RestartingSkip   = "skip"   // don't update the container
+)
+
+// errRestartingSkipped is returned by stopRestarting if the container should not be updated
+var errRestartingSkipped = errors.New("container is restarting, skipped")
+
+// stopRestarting prepares a restarting container for being stopped.
+// It returns true if the container was already killed.
+// The restart policy of the re-created container is restored from inspect.HostConfig
+func (a *attributes) stopRestarting(dctx context.Context, inspect *types.ContainerJSON) (killed bool, err error) {
+	switch a.restarting {
+	case RestartingSkip:
+		return false, errRestartingSkipped
+	case RestartingKill:
+		log.Infof("Killing restarting container %s", trimID(inspect.ID))
+		if err = dc.ContainerKill(dctx, inspect.ID, "SIGKILL"); err != nil {
+			return
+		}
+		return true, nil
+	default:
+		log.Infof("Disabling restart policy of restarting container %s", trimID(inspect.ID))
+		_, err = dc.ContainerUpdate(dctx, inspect.ID, container.UpdateConfig{
+			RestartPolicy: container.RestartPolicy{Name: "no"},
+		})
+		return false, err
+	}
+}
+
+// stampDeploy adds labels with deploy metadata to the config of a container
+func stampDeploy(dctx context.Context, config *container.Config, webhook string) {
+	if config.Labels == nil {
+		config.Labels = make(map[string]string)
+	}
+	config.Labels[LabelDeployedAt] = time.Now().UTC().Format(time.RFC3339)
+	config.Labels[LabelDeployedBy] = webhook
+
+	// digest of the image the container is created with
+	img, _, err := dc.ImageInspectWithRaw(dctx, config.Image)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot inspect image %s for deploy stamp", config.Image)
+		return
+	}
+	if len(img.RepoDigests) > 0 {
+		config.Labels[LabelDeployedDigest] = img.RepoDigests[0]
+	} else {
+		config.Labels[LabelDeployedDigest] = img.ID
+	}
+}
+
+// alreadyDeployed checks if the container was stamped by webhook with the image its reference currently points to
+func alreadyDeployed(dctx context.Context, cont *types.Container, webhook string) (bool, error) {
+	digest := cont.Labels[LabelDeployedDigest]
+	if cont.Labels[LabelDeployedBy] != webhook || digest == "" {
+		return false, nil
+	}
+	img, _, err := dc.ImageInspectWithRaw(dctx, cont.Image)
+	if err != nil {
+		return false, err
+	}
+	if img.ID != cont.ImageID {
+		return false, nil
+	}
+	if digest == img.ID {
+		return true, nil
+	}
+	for _, rd := range img.RepoDigests {
+		if rd == digest {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// canonicalName returns the name of a container from the names reported by the Docker API.
+// Names of legacy links (/other/alias) are ignored, the order of names is not relied on
+func canonicalName(names []string) (name string) {
+	for _, n := range names {
+		if strings.Count(n, "/") > 1 {
+			continue
+		}
+		if name == "" || n < name {
+			name = n
+		}
+	}
+	return
+}
+
+// linkAliases returns the names of a container which are aliases of legacy links
+func linkAliases(names []string) (aliases []string) {
+	for _, n := range names {
+		if strings.Count(n, "/") > 1 {
+			aliases = append(aliases, n)
+		}
+	}
+	return
+}
+
+// checkName returns an error if the container name belongs to a container other than previousID
+func checkName(dctx context.Context, name, previousID string) error {
+	if name == "" {
+		return nil
+	}
+	inspect, err := dc.ContainerInspect(dctx, name)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if inspect.ID == previousID {
+		return nil
+	}
+	return fmt.Errorf("name %s now belongs to container %s (image %s), which is not the updated container %s",
+		strings.TrimPrefix(name, "/"), trimID(inspect.ID), inspect.Config.Image, trimID(previousID))
+}
+
+// defaultStopTimeout is the time a container has to stop before it's killed
+const defaultStopTimeout = time.Minute
+
+// stopTimeout returns the stop timeout of an inspected container from its label, the override of the webhook
+// (if not negative), the stop timeout it was created with (docker run --stop-timeout) or the default
+func stopTimeout(inspect *types.ContainerJSON, override time.Duration) time.Duration {
+	if inspect.Config == nil {
+		return defaultStopTimeout
+	}
+	if v, ok := inspect.Config.Labels[LabelStopTimeout]; ok {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err == nil && d >= 0 {
+			return d
+		}
+		log.Warnf("Invalid stop timeout of container %s: %s", trimID(inspect.ID), v)
+	}
+	if override >= 0 {
+		return override
+	}
+	if inspect.Config.StopTimeout != nil && *inspect.Config.StopTimeout >= 0 {
+		return time.Duration(*inspect.Config.StopTimeout) * time.Second
+	}
+	return defaultStopTimeout
+}
+
+// sortByOrder sorts containers by their order label ascending. Containers without a (valid) label are updated last,
+// containers with the same order keep the order they were matched in
+func sortByOrder(containers []types.Container) {
+	orders := make(map[string]int, len(containers))
+	for _, c := range containers {
+		orders[c.ID] = math.MaxInt
+		v, ok := c.Labels[LabelOrder]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			log.Warnf("Invalid order of container %s: %s", trimID(c.ID), v)
+			continue
+		}
+		orders[c.ID] = n
+	}
+	sort.SliceStable(containers, func(i, j int) bool {
+		return orders[containers[i].ID] < orders[containers[j].ID]
+	})
+}
+
+// networkingConfig returns the networking config for re-creating an inspected container.
+// Containers using the host, none or another container's network stack keep their network mode
+// (from the host config) but must not get endpoint settings
+func networkingConfig(inspect *types.ContainerJSON) *network.NetworkingConfig {
+	mode := inspect.HostConfig.NetworkMode
+	switch {
+	case mode.IsHost(), mode.IsNone():
+		log.Debugf("Keeping network mode %s of container %s", mode, trimID(inspect.ID))
+		return &network.NetworkingConfig{}
+	case mode.IsContainer():
+		// the hostname and mac address are inherited from the other container and conflict with this mode
+		log.Debugf("Keeping network mode %s of container %s", mode, trimID(inspect.ID))
+		inspect.Config.Hostname, inspect.Config.Domainname, inspect.Config.MacAddress = "", "", ""
+		return &network.NetworkingConfig{}
+	}
+	var networks map[string]*network.EndpointSettings
+	if inspect.NetworkSettings != nil {
+		networks = inspect.NetworkSettings.Networks
+	}
+	// network aliases are kept, except the short ID of the old container which Docker adds by itself
+	for _, ep := range networks {
+		if ep == nil {
+			continue
+		}
+		aliases := ep.Aliases[:0]
+		for _, alias := range ep.Aliases {
+			if len(alias) != 12 || !strings.HasPrefix(inspect.ID, alias) {
+				aliases = append(aliases, alias)
+			}
+		}
+		ep.Aliases = aliases
+	}
+	return &network.NetworkingConfig{EndpointsConfig: networks}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNetworkingConfig(t *testing.T) {
+	const id = "0123456789abcdef"
+	inspected := func(mode string, networks map[string]*network.EndpointSettings) *types.ContainerJSON {
+		return &types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         id,
+				HostConfig: &container.HostConfig{NetworkMode: container.NetworkMode(mode)},
+			},
+			Config:          &container.Config{Hostname: "app", MacAddress: "02:42:ac:11:00:02"},
+			NetworkSettings: &types.NetworkSettings{Networks: networks},
+		}
+	}
+	tests := []struct {
+		name     string
+		inspect  *types.ContainerJSON
+		networks []string // expected endpoints
+		aliases  []string // expected aliases of the first network
+	}{
+		{
+			name:    "host",
+			inspect: inspected("host", map[string]*network.EndpointSettings{"host": {}}),
+		},
+		{
+			name:    "none",
+			inspect: inspected("none", nil),
+		},
+		{
+			name:    "container",
+			inspect: inspected("container:other", nil),
+		},
+		{
+			name: "bridge",
+			inspect: inspected("default", map[string]*network.EndpointSettings{
+				"bridge": {},
+			}),
+			networks: []string{"bridge"},
+		},
+		{
+			name: "multiple networks",
+			inspect: inspected("frontend", map[string]*network.EndpointSettings{
+				"frontend": {Aliases: []string{"web", id[:12]}},
+				"backend":  {Aliases: []string{"web-internal"}},
+			}),
+			networks: []string{"backend", "frontend"},
+			aliases:  []string{"web-internal"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := networkingConfig(tt.inspect)
+			var networks []string
+			for n := range cfg.EndpointsConfig {
+				networks = append(networks, n)
+			}
+			sort.Strings(networks)
+			if !reflect.DeepEqual(networks, tt.networks) {
+				t.Errorf("expected networks %v, got %v", tt.networks, networks)
+			}
+			if tt.aliases != nil {
+				if got := cfg.EndpointsConfig[tt.networks[0]].Aliases; !reflect.DeepEqual(got, tt.aliases) {
+					t.Errorf("expected aliases %v, got %v", tt.aliases, got)
+				}
+			}
+		})
+	}
+
+	// the short ID of the old container is dropped from the aliases
+	inspect := inspected("frontend", map[string]*network.EndpointSettings{
+		"frontend": {Aliases: []string{"web", id[:12]}},
+	})
+	if got := networkingConfig(inspect).EndpointsConfig["frontend"].Aliases; !reflect.DeepEqual(got, []string{"web"}) {
+		t.Errorf("expected aliases [web], got %v", got)
+	}
+
+	// the network stack of another container comes with its hostname
+	inspect = inspected("container:other", nil)
+	networkingConfig(inspect)
+	if inspect.Config.Hostname != "" || inspect.Config.MacAddress != "" {
+		t.Errorf("expected hostname and mac address to be cleared, got %q and %q",
+			inspect.Config.Hostname, inspect.Config.MacAddress)
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"/db"}, "/db"},
+		{[]string{"/app/db", "/db"}, "/db"},
+		{[]string{"/db", "/app/db"}, "/db"},
+		{[]string{"/web", "/api"}, "/api"},
+		{[]string{"/app/db"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := canonicalName(tt.names); got != tt.want {
+			t.Errorf("canonicalName(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	seconds := func(s int) *int { return &s }
+	tests := []struct {
+		name     string
+		config   *container.Config
+		override time.Duration
+		want     time.Duration
+	}{
+		{"no config", nil, -1, defaultStopTimeout},
+		{"default", &container.Config{}, -1, defaultStopTimeout},
+		{"config", &container.Config{StopTimeout: seconds(30)}, -1, 30 * time.Second},
+		{"negative config", &container.Config{StopTimeout: seconds(-1)}, -1, defaultStopTimeout},
+		{"override", &container.Config{StopTimeout: seconds(30)}, 5 * time.Second, 5 * time.Second},
+		{"label", &container.Config{
+			StopTimeout: seconds(30),
+			Labels:      map[string]string{LabelStopTimeout: " 2m "},
+		}, 5 * time.Second, 2 * time.Minute},
+		{"invalid label", &container.Config{
+			StopTimeout: seconds(30),
+			Labels:      map[string]string{LabelStopTimeout: "soon"},
+		}, -1, 30 * time.Second},
+		{"negative label", &container.Config{
+			Labels: map[string]string{LabelStopTimeout: "-1s"},
+		}, 5 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspect := &types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{ID: "0123456789abcdef"},
+				Config:            tt.config,
+			}
+			if got := stopTimeout(inspect, tt.override); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// cosignBinary is the cosign executable used to verify signatures
+var cosignBinary = "cosign"
+
+// errSignatureInvalid is returned if cosign rejected the signature of an image
+var errSignatureInvalid = errors.New("signature invalid")
+
+// verifySignature verifies the cosign signature of the pulled image ref against the public key of the webhook.
+// The image is verified by its digest so the verified image is the one that will be deployed.
+// cosign reads the signature from the registry with the credentials the image is pulled with
+func (a *attributes) verifySignature(dctx context.Context, ref string) (err error) {
+	var pinned string
+	if pinned, err = pinnedRef(dctx, ref); err != nil {
+		return
+	}
+	cmd := exec.CommandContext(dctx, cosignBinary, "verify", "--key", a.cosignKey, pinned)
+	if auth := a.imageAuth(dctx, ref); auth != "" {
+		dir, err := cosignConfig(ref, auth)
+		if err != nil {
+			return fmt.Errorf("cannot pass registry credentials to cosign: %v", err)
+		}
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				log.WithError(err).Warnf("Cannot remove %s", dir)
+			}
+		}()
+		cmd.Env = append(os.Environ(), EnvDockerConfigDir+"="+dir)
+	}
+	log.Infof("Verifying signature of %s", pinned)
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && dctx.Err() == nil {
+		return fmt.Errorf("%w: %s: %s", errSignatureInvalid, pinned, strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		return fmt.Errorf("cannot verify signature of %s: %v", pinned, err)
+	}
+	return nil
+}
+
+// cosignConfig writes the encoded registry auth of ref as config.json into a new temporary directory,
+// which is used as DOCKER_CONFIG by cosign. The directory has to be removed by the caller
+func cosignConfig(ref, auth string) (dir string, err error) {
+	host, err := registryHost(ref)
+	if err != nil {
+		return "", err
+	}
+	creds, err := decodeAuth(auth)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry auth: %v", err)
+	}
+	entry := map[string]string{}
+	if creds.Username != "" || creds.Password != "" {
+		entry["auth"] = base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+	}
+	if creds.IdentityToken != "" {
+		entry["identitytoken"] = creds.IdentityToken
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{configKeys(host)[0]: entry},
+	})
+	if err != nil {
+		return "", err
+	}
+	if dir, err = os.MkdirTemp("", "yadwh-cosign-"); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "config.json"), data, 0600); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignatureAuth(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake cosign is a shell script")
+	}
+	const digest = "registry.example.com/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/images/") {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, types.ImageInspect{ID: "sha256:app", RepoDigests: []string{digest}})
+	})
+
+	// the fake cosign keeps the Docker config it was started with
+	dir := t.TempDir()
+	out := filepath.Join(dir, "config.json")
+	script := filepath.Join(dir, "cosign")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat \"$DOCKER_CONFIG/config.json\" > "+out+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	prev := cosignBinary
+	cosignBinary = script
+	t.Cleanup(func() { cosignBinary = prev })
+
+	// WH_AUTH_<NAME> is standard base64
+	auth := base64.StdEncoding.EncodeToString([]byte(`{"username":"ci","password":"s3cr3t"}`))
+	a := &attributes{cosignKey: "cosign.pub", auth: auth}
+	if err := a.verifySignature(context.Background(), "registry.example.com/app:latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("expected cosign to get a Docker config: %v", err)
+	}
+	var cfg dockerConfig
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := base64.StdEncoding.EncodeToString([]byte("ci:s3cr3t"))
+	if got := cfg.Auths["registry.example.com"].Auth; got != want {
+		t.Errorf("expected the credentials of the webhook for registry.example.com, got %s", data)
+	}
+}
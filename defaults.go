@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+// equalStrings compares two string slices, treating nil and empty slices equally
+func equalStrings(a, b strslice.StrSlice) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDefaults compares the entrypoint and cmd of the old image, the new image and the container.
+// A field is considered overridden if the container value differs from the old image default,
+// since Docker copies image defaults into the container config on create.
+// If adopt is true, fields which were not overridden are set to the new image defaults.
+// It returns warnings for every changed default.
+func checkDefaults(dctx context.Context, config *container.Config, oldImageID string, adopt bool) (warnings []string, err error) {
+	oldImg, _, err := dc.ImageInspectWithRaw(dctx, oldImageID)
+	if err != nil {
+		return nil, err
+	}
+	newImg, _, err := dc.ImageInspectWithRaw(dctx, config.Image)
+	if err != nil {
+		return nil, err
+	}
+	if oldImg.ID == newImg.ID || oldImg.Config == nil || newImg.Config == nil {
+		return nil, nil
+	}
+
+	fields := []struct {
+		name                   string
+		current                *strslice.StrSlice
+		oldDefault, newDefault strslice.StrSlice
+	}{
+		{"entrypoint", &config.Entrypoint, oldImg.Config.Entrypoint, newImg.Config.Entrypoint},
+		{"cmd", &config.Cmd, oldImg.Config.Cmd, newImg.Config.Cmd},
+	}
+	for _, f := range fields {
+		if equalStrings(f.oldDefault, f.newDefault) {
+			continue
+		}
+		switch {
+		case !equalStrings(*f.current, f.oldDefault):
+			warnings = append(warnings, fmt.Sprintf("default %s of image changed to %q, keeping overridden %q",
+				f.name, f.newDefault, *f.current))
+		case adopt:
+			*f.current = f.newDefault
+			warnings = append(warnings, fmt.Sprintf("adopted new default %s %q of image", f.name, f.newDefault))
+		default:
+			warnings = append(warnings, fmt.Sprintf("default %s of image changed from %q to %q, keeping old value",
+				f.name, f.oldDefault, f.newDefault))
+		}
+	}
+	return
+}
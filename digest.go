@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestCacheTTL is the time a remote digest is cached
+const digestCacheTTL = time.Minute
+
+type cachedDigest struct {
+	digest string
+	at     time.Time
+}
+
+var (
+	digestCache   = make(map[string]cachedDigest)
+	digestCacheMu sync.Mutex
+)
+
+// remoteDigest returns the digest of the image ref in its registry without pulling it.
+// Results are cached for digestCacheTTL
+func remoteDigest(dctx context.Context, ref, auth string) (string, error) {
+	digestCacheMu.Lock()
+	cached, ok := digestCache[ref]
+	digestCacheMu.Unlock()
+	if ok && time.Since(cached.at) < digestCacheTTL {
+		return cached.digest, nil
+	}
+
+	if err := waitRegistry(dctx, ref); err != nil {
+		return "", err
+	}
+	inspect, err := dc.DistributionInspect(dctx, ref, auth)
+	registryDone(ref, err)
+	if err != nil {
+		return "", err
+	}
+	digest := inspect.Descriptor.Digest.String()
+
+	digestCacheMu.Lock()
+	digestCache[ref] = cachedDigest{digest: digest, at: time.Now()}
+	digestCacheMu.Unlock()
+	return digest, nil
+}
+
+// hasDigest checks if the local image imageID was pulled with the given digest
+func hasDigest(dctx context.Context, imageID, digest string) (bool, error) {
+	var (
+		img types.ImageInspect
+		err error
+	)
+	if img, _, err = dc.ImageInspectWithRaw(dctx, imageID); err != nil {
+		return false, err
+	}
+	for _, rd := range img.RepoDigests {
+		if strings.HasSuffix(rd, "@"+digest) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pinnedRef returns the digest reference (repo@sha256:...) of the local image ref,
+// or ref itself if the image has no digest of the same repository
+func pinnedRef(dctx context.Context, ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := dc.ImageInspectWithRaw(dctx, ref)
+	if err != nil {
+		return "", err
+	}
+	for _, rd := range img.RepoDigests {
+		if n, err := reference.ParseNormalizedNamed(rd); err == nil && n.Name() == named.Name() {
+			return rd, nil
+		}
+	}
+	return ref, nil
+}
+
+// withTag returns the image reference ref with its tag (or digest) replaced by tag
+func withTag(ref, tag string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	tagged, err := reference.WithTag(reference.TrimNamed(named), tag)
+	if err != nil {
+		return "", err
+	}
+	return reference.FamiliarString(tagged), nil
+}
+
+// normalizeRef returns the fully qualified form of ref (e.g. docker.io/library/nginx:latest)
+func normalizeRef(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.TagNameOnly(named).String()
+}
+
+// imageChanged checks if the local image of the reference the container was created from
+// differs from the image the container is running
+func imageChanged(dctx context.Context, cont *types.Container) (bool, error) {
+	img, _, err := dc.ImageInspectWithRaw(dctx, cont.Image)
+	if err != nil {
+		return false, err
+	}
+	return img.ID != cont.ImageID, nil
+}
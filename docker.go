@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"os"
+	"time"
+)
+
+// waitForDocker pings the Docker daemon with exponential backoff until it responds or timeout elapsed.
+// The API version is negotiated with the first response, so older daemons are supported.
+// If timeout is 0, the daemon is pinged only once
+func waitForDocker(timeout time.Duration) (err error) {
+	deadline := time.Now().Add(timeout)
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		var ping types.Ping
+		if ping, err = dc.Ping(context.Background()); err == nil {
+			log.Debug("Negotiating API version for Docker client")
+			dc.NegotiateAPIVersionPing(ping)
+			if _, err = dc.Info(context.Background()); err == nil {
+				return nil
+			}
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return
+		}
+		log.WithError(err).Infof("Docker is not ready yet (attempt %d), retrying in %s", attempt, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > 10*time.Second {
+			delay = 10 * time.Second
+		}
+	}
+}
+
+// readyEvent is written to stdout once yadwh is ready
+type readyEvent struct {
+	Event    string    `json:"event"`
+	Addr     string    `json:"addr"`
+	Webhooks int       `json:"webhooks"`
+	Version  string    `json:"version"`
+	Time     time.Time `json:"time"`
+}
+
+// emitReadyEvent writes a single JSON line to stdout for supervising processes.
+// It's called after the server is listening, Docker was reachable before
+func emitReadyEvent(addr string) error {
+	return json.NewEncoder(os.Stdout).Encode(readyEvent{
+		Event:    "ready",
+		Addr:     addr,
+		Webhooks: len(attrs),
+		Version:  version,
+		Time:     time.Now(),
+	})
+}
+
+// healthPingTimeout bounds the ping of the Docker daemon by /healthz
+const healthPingTimeout = 2 * time.Second
+
+// handleHealthz returns 200 if the Docker daemon is reachable, 503 otherwise
+func handleHealthz(ctx *fiber.Ctx) error {
+	if dc == nil {
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status": "unavailable",
+			"error":  "docker client not initialized",
+		})
+	}
+	pctx, cancel := context.WithTimeout(ctx.Context(), healthPingTimeout)
+	defer cancel()
+	if _, err := dc.Ping(pctx); err != nil {
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status": "unavailable",
+			"error":  err.Error(),
+		})
+	}
+	return ctx.JSON(fiber.Map{"status": "ok"})
+}
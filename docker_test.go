@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/moby/moby/client"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// apiVersionPrefix matches the version prefix of Docker API paths, e.g. /v1.41
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// fakeDocker points dc at a Docker API served by handler until the test finished.
+// The API version prefix is stripped from the paths passed to handler
+func fakeDocker(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		handler(w, r)
+	}))
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+srv.Listener.Addr().String()), client.WithVersion("1.41"))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	prev := dc
+	dc = cli
+	t.Cleanup(func() {
+		dc = prev
+		_ = cli.Close()
+		srv.Close()
+	})
+}
+
+// writeJSON answers a fake Docker API request with v
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// notFound answers a fake Docker API request like the daemon does for unknown objects
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "No such object"})
+}
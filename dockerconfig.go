@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerConfigPath is the path to a Docker config.json, disabled if empty
+var dockerConfigPath string
+
+// dockerConfig is the relevant part of a Docker config.json
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// configFile returns the path of the config.json at path, which may be the file or its directory
+func configFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	path = filepath.Join(path, "config.json")
+	if _, err = os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// registryHost returns the registry host of an image reference
+func registryHost(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
+
+// configKeys returns the keys a registry may be stored with in a config.json
+func configKeys(host string) []string {
+	if host == "docker.io" {
+		return []string{"https://index.docker.io/v1/", "index.docker.io", "docker.io"}
+	}
+	return []string{host, "https://" + host, "http://" + host}
+}
+
+// encodeAuth encodes an auth config for the RegistryAuth of the Docker API
+func encodeAuth(auth types.AuthConfig) (string, error) {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// decodeAuth decodes the RegistryAuth of the Docker API or WH_AUTH_<NAME>, which may be padded or URL-safe base64
+func decodeAuth(encoded string) (auth types.AuthConfig, err error) {
+	var data []byte
+	for _, enc := range []*base64.Encoding{base64.URLEncoding, base64.StdEncoding, base64.RawURLEncoding, base64.RawStdEncoding} {
+		if data, err = enc.DecodeString(encoded); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &auth)
+	return
+}
+
+// credentialHelper gets the credentials for server from docker-credential-<helper>
+func credentialHelper(helper, server string) (auth types.AuthConfig, err error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var out []byte
+	if out, err = cmd.Output(); err != nil {
+		return auth, fmt.Errorf("credential helper %s: %v", helper, err)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err = json.NewDecoder(bytes.NewReader(out)).Decode(&creds); err != nil {
+		return
+	}
+	auth.ServerAddress = server
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return
+}
+
+// configAuth returns the encoded auth for the registry of ref from the Docker config.json.
+// It returns an empty string if the config has no matching entry
+func configAuth(ref string) (string, error) {
+	host, err := registryHost(ref)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(dockerConfigPath)
+	if err != nil {
+		return "", err
+	}
+	var cfg dockerConfig
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return "", err
+	}
+
+	keys := configKeys(host)
+	// credential helper for the registry
+	for _, key := range keys {
+		if helper, ok := cfg.CredHelpers[key]; ok {
+			auth, err := credentialHelper(helper, key)
+			if err != nil {
+				return "", err
+			}
+			return encodeAuth(auth)
+		}
+	}
+	// static credentials
+	for _, key := range keys {
+		entry, ok := cfg.Auths[key]
+		if !ok {
+			continue
+		}
+		auth := types.AuthConfig{ServerAddress: key, IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			var dec []byte
+			if dec, err = base64.StdEncoding.DecodeString(entry.Auth); err != nil {
+				return "", fmt.Errorf("invalid auth of %s: %v", key, err)
+			}
+			parts := strings.SplitN(string(dec), ":", 2)
+			auth.Username = parts[0]
+			if len(parts) == 2 {
+				auth.Password = parts[1]
+			}
+		} else if cfg.CredsStore != "" {
+			// entry is only a marker for the credential store
+			if auth, err = credentialHelper(cfg.CredsStore, key); err != nil {
+				return "", err
+			}
+		}
+		return encodeAuth(auth)
+	}
+	return "", nil
+}
+
+// registryAuth returns the auth for pulling ref. Credentials from the Docker config.json
+// take precedence over fallback (the auth of the webhook)
+func registryAuth(ref, fallback string) string {
+	if dockerConfigPath == "" {
+		return fallback
+	}
+	auth, err := configAuth(ref)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot read credentials for %s from %s", ref, dockerConfigPath)
+		return fallback
+	}
+	if auth == "" {
+		return fallback
+	}
+	return auth
+}
+
+// errAuthFailed is returned if the registry denied access to an image
+var errAuthFailed = errors.New("registry authentication failed")
+
+// isAuthError checks if err was caused by missing or invalid registry credentials
+func isAuthError(err error) bool {
+	if errdefs.IsUnauthorized(err) || errdefs.IsForbidden(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"unauthorized", "authentication required", "access denied", "denied:", "403 forbidden"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
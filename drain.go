@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/gofiber/fiber/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainTimeout is the maximum time running updates are waited for on shutdown
+var drainTimeout = 30 * time.Second
+
+var (
+	// draining is 1 once the server shuts down
+	draining int32
+	// active tracks all running updates
+	active sync.WaitGroup
+	// activeWebhooks contains the number of running updates by webhook name.
+	// activeWebhooksMu also guards adding to active against draining
+	activeWebhooks   = make(map[string]int)
+	activeWebhooksMu sync.Mutex
+)
+
+// ErrShuttingDown is returned by webhooks while running updates are drained
+var ErrShuttingDown = fiber.NewError(fiber.StatusServiceUnavailable, "shutting down")
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// track marks an update of the webhook name as running until the returned function is called.
+// Updates waiting for a running update are tracked as well. Returns false once the server is draining
+func track(name string) (untrack func(), ok bool) {
+	activeWebhooksMu.Lock()
+	defer activeWebhooksMu.Unlock()
+	if isDraining() {
+		return nil, false
+	}
+	active.Add(1)
+	activeWebhooks[name]++
+	return func() {
+		activeWebhooksMu.Lock()
+		if activeWebhooks[name]--; activeWebhooks[name] <= 0 {
+			delete(activeWebhooks, name)
+		}
+		activeWebhooksMu.Unlock()
+		active.Done()
+	}, true
+}
+
+// running returns the names of all webhooks with running updates
+func running() (names []string) {
+	activeWebhooksMu.Lock()
+	defer activeWebhooksMu.Unlock()
+	for name := range activeWebhooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// drain rejects new updates and waits up to timeout for running updates to finish.
+// Returns false if updates were still running after the timeout
+func drain(timeout time.Duration) bool {
+	activeWebhooksMu.Lock()
+	atomic.StoreInt32(&draining, 1)
+	activeWebhooksMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		active.Wait()
+		close(done)
+	}()
+	if names := running(); len(names) > 0 {
+		log.WithField("webhooks", names).Infof("Waiting up to %s for running updates", timeout)
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		log.WithField("webhooks", running()).Warn("Running updates didn't finish in time, cancelling them")
+		return false
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	t.Cleanup(func() { atomic.StoreInt32(&draining, 0) })
+
+	// e.g. an update waiting for the running update of its webhook
+	untrack, ok := track("WEB")
+	if !ok {
+		t.Fatal("expected the update to be tracked")
+	}
+	if drain(10 * time.Millisecond) {
+		t.Error("expected drain to wait for the tracked update")
+	}
+	if _, ok = track("WEB"); ok {
+		t.Error("expected new updates to be rejected while draining")
+	}
+	untrack()
+	if !drain(time.Second) {
+		t.Error("expected drain to finish once the update was untracked")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/docker/docker/api/types"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ecrHost matches the registry host of ECR repositories and captures the account and region
+var ecrHost = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ecrRefreshBefore is the time before their expiry ECR tokens are renewed
+const ecrRefreshBefore = 30 * time.Minute
+
+type ecrToken struct {
+	auth    string
+	expires time.Time
+}
+
+var (
+	ecrTokens   = make(map[string]ecrToken) // by registry host
+	ecrTokensMu sync.Mutex
+)
+
+// ecrAuth returns the encoded auth for the ECR registry of ref, false if ref is not in ECR.
+// Tokens are requested with the ambient AWS credentials (environment, shared config or role)
+// and cached until shortly before they expire
+func ecrAuth(dctx context.Context, ref string) (string, bool, error) {
+	host, err := registryHost(ref)
+	if err != nil {
+		return "", false, err
+	}
+	m := ecrHost.FindStringSubmatch(host)
+	if m == nil {
+		return "", false, nil
+	}
+
+	ecrTokensMu.Lock()
+	defer ecrTokensMu.Unlock()
+	if t, ok := ecrTokens[host]; ok && time.Until(t.expires) > ecrRefreshBefore {
+		return t.auth, true, nil
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(m[2])})
+	if err != nil {
+		return "", true, err
+	}
+	out, err := ecr.New(sess).GetAuthorizationTokenWithContext(dctx, &ecr.GetAuthorizationTokenInput{
+		RegistryIds: []*string{aws.String(m[1])},
+	})
+	if err != nil {
+		return "", true, err
+	}
+	if len(out.AuthorizationData) == 0 {
+		return "", true, fmt.Errorf("no authorization data for %s", host)
+	}
+	data := out.AuthorizationData[0]
+	dec, err := base64.StdEncoding.DecodeString(aws.StringValue(data.AuthorizationToken))
+	if err != nil {
+		return "", true, err
+	}
+	parts := strings.SplitN(string(dec), ":", 2)
+	if len(parts) != 2 {
+		return "", true, fmt.Errorf("invalid authorization token for %s", host)
+	}
+	auth, err := encodeAuth(types.AuthConfig{Username: parts[0], Password: parts[1], ServerAddress: host})
+	if err != nil {
+		return "", true, err
+	}
+	expires := aws.TimeValue(data.ExpiresAt)
+	log.Infof("Refreshed ECR token of %s, valid until %s", host, expires.Format(time.RFC3339))
+	ecrTokens[host] = ecrToken{auth: auth, expires: expires}
+	return auth, true, nil
+}
+
+// imageAuth returns the auth of the webhook for the registry of ref,
+// a fresh ECR token if enabled and ref is in ECR
+func (a *attributes) imageAuth(dctx context.Context, ref string) string {
+	if a.ecr {
+		auth, ok, err := ecrAuth(dctx, ref)
+		if err != nil {
+			log.WithError(err).Warnf("Cannot get ECR token for %s", ref)
+		} else if ok {
+			return auth
+		}
+	}
+	return registryAuth(ref, a.auth)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// eventBuffer is the maximum amount of events waiting to be published
+const eventBuffer = 64
+
+// deployEvent is published after each update
+type deployEvent struct {
+	Webhook   string               `json:"webhook"`
+	Time      time.Time            `json:"time"`
+	Images    []string             `json:"images"`
+	Restarted []restartedContainer `json:"restarted"`
+}
+
+var (
+	eventURL     *url.URL // nats://[user:pass@]host:port or redis://[:pass@]host:port
+	eventSubject = "yadwh.deploy"
+	events       chan *deployEvent
+)
+
+// startEventPublisher starts publishing events in the background
+func startEventPublisher(rawURL, subject string) (err error) {
+	if eventURL, err = url.Parse(rawURL); err != nil {
+		return
+	}
+	switch eventURL.Scheme {
+	case "nats", "redis":
+	default:
+		return fmt.Errorf("unsupported event url scheme: %s", eventURL.Scheme)
+	}
+	if subject != "" {
+		eventSubject = subject
+	}
+	events = make(chan *deployEvent, eventBuffer)
+	go func() {
+		for ev := range events {
+			if err := notifyRetry.do(shutdownCtx, func(dctx context.Context) error {
+				return publish(dctx, ev)
+			}); err != nil {
+				log.WithError(err).Warnf("Cannot publish event of %s", ev.Webhook)
+			}
+		}
+	}()
+	return nil
+}
+
+// emitEvent queues an event without blocking, events are dropped if the buffer is full
+func emitEvent(ev *deployEvent) {
+	if events == nil {
+		return
+	}
+	select {
+	case events <- ev:
+	default:
+		log.Warnf("Event buffer full, dropping event of %s", ev.Webhook)
+	}
+}
+
+// publish sends ev to the configured message queue using a short-lived connection
+func publish(dctx context.Context, ev *deployEvent) (err error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	dctx, cancel := context.WithTimeout(dctx, notifyTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(dctx, "tcp", eventURL.Host)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := dctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	if eventURL.Scheme == "nats" {
+		return publishNATS(conn, r, payload)
+	}
+	return publishRedis(conn, r, payload)
+}
+
+// publishNATS publishes payload using the NATS text protocol
+func publishNATS(conn net.Conn, r *bufio.Reader, payload []byte) (err error) {
+	// server greets with INFO
+	if _, err = r.ReadString('\n'); err != nil {
+		return
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "yadwh"}
+	if u := eventURL.User; u != nil {
+		opts["user"] = u.Username()
+		opts["pass"], _ = u.Password()
+	}
+	connect, _ := json.Marshal(opts)
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n",
+		connect, eventSubject, len(payload), payload); err != nil {
+		return
+	}
+	// PONG confirms that the message was processed
+	var line string
+	if line, err = r.ReadString('\n'); err != nil {
+		return
+	}
+	if !strings.HasPrefix(line, "PONG") {
+		return errors.New("nats: " + strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// publishRedis publishes payload using the Redis PUBLISH command
+func publishRedis(conn net.Conn, r *bufio.Reader, payload []byte) (err error) {
+	command := func(args ...string) error {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+		}
+		if _, err := conn.Write([]byte(b.String())); err != nil {
+			return err
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "-") {
+			return errors.New("redis: " + strings.TrimSpace(line[1:]))
+		}
+		return nil
+	}
+	if u := eventURL.User; u != nil {
+		if pass, ok := u.Password(); ok {
+			if err = command("AUTH", pass); err != nil {
+				return
+			}
+		}
+	}
+	return command("PUBLISH", eventSubject, string(payload))
+}
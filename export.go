@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in the exported configuration
+const redacted = "<redacted>"
+
+// formatDuration returns d as string or an empty string if d is 0
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// exportConfig returns the loaded webhooks in the format of the configuration file.
+// Secrets and registry credentials are redacted unless withSecrets is true
+func exportConfig(withSecrets bool) *fileConfig {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cfg := &fileConfig{Webhooks: make([]*webhookConfig, 0, len(attrs))}
+	for _, name := range names {
+		a := attrs[name]
+		w := &webhookConfig{
+			Name:           name,
+			Secret:         a.secret,
+			Auth:           a.auth,
+			AuthFailFast:   a.authFailFast,
+			ECR:            a.ecr,
+			RemoveOld:      a.removeOld,
+			Prune:          a.prune,
+			MaxDuration:    formatDuration(a.maxDuration),
+			Atomic:         a.atomic,
+			SwapDelay:      formatDuration(a.swapDelay),
+			ZeroDowntime:   a.zeroDowntime,
+			HealthTimeout:  formatDuration(a.healthTimeout),
+			HealthRollback: a.healthRollback,
+			Stabilize:      formatDuration(a.stabilization),
+			Restarting:     a.restarting,
+			Stamp:          a.stamp,
+			Resume:         a.resume,
+			Force:          a.force,
+			CosignKey:      a.cosignKey,
+			AdoptDefaults:  a.adoptDefaults,
+			Platform:       a.platform,
+			Selector:       a.selector,
+			Containers:     a.containers,
+			Methods:        a.methods,
+			MatchExpr:      a.matchExpr,
+			ConflictMode:   a.conflictMode,
+			QueueTimeout:   formatDuration(a.queueTimeout),
+			Async:          a.async,
+			PollInterval:   formatDuration(a.pollInterval),
+			WatchPath:      a.watchPath,
+			MTLS:           a.mtls,
+			Signature:      a.signature,
+			Cascade:        a.cascade,
+			AuditMode:      a.audit,
+			DryRun:         a.dryRun,
+			Slack:          a.slackURL,
+			NotifyURL:      a.notifyURL,
+		}
+		if a.stopTimeout >= 0 {
+			seconds := int(a.stopTimeout / time.Second)
+			w.StopTimeout = &seconds
+		}
+		// defaults are omitted
+		if a.healthInterval != defaultHealthInterval {
+			w.HealthInterval = formatDuration(a.healthInterval)
+		}
+		if a.healthBackoff != 1 {
+			w.HealthBackoff = a.healthBackoff
+		}
+		if w.Restarting == RestartingPolicy {
+			w.Restarting = ""
+		}
+		if w.ConflictMode == ConflictQueue {
+			w.ConflictMode = ""
+		}
+		if a.mode != ModeUpdate {
+			w.Mode = a.mode
+		}
+		if a.concurrency != 1 {
+			w.Concurrency = a.concurrency
+		}
+		for r := range a.allowResources {
+			w.AllowResources = append(w.AllowResources, r)
+		}
+		sort.Strings(w.AllowResources)
+		var ports []string
+		for _, r := range a.allowPorts {
+			if r.start == r.end {
+				ports = append(ports, strconv.FormatUint(r.start, 10))
+			} else {
+				ports = append(ports, fmt.Sprintf("%d-%d", r.start, r.end))
+			}
+		}
+		w.AllowPorts = strings.Join(ports, ",")
+		if !withSecrets {
+			w.Secret = redacted
+			if w.Auth != "" {
+				w.Auth = redacted
+			}
+			// the URL of an incoming webhook is its credential
+			if w.Slack != "" {
+				w.Slack = redacted
+			}
+			if w.NotifyURL != "" {
+				w.NotifyURL = redacted
+			}
+		}
+		cfg.Webhooks = append(cfg.Webhooks, w)
+	}
+	return cfg
+}
+
+// writeConfig writes the loaded webhooks as YAML to path
+func writeConfig(path string, withSecrets bool) error {
+	if withSecrets {
+		log.Warn("!! The exported configuration contains secrets and registry credentials in plain text !!")
+		log.Warnf("!! Protect %s accordingly !!", path)
+	}
+	data, err := yaml.Marshal(exportConfig(withSecrets))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
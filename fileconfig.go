@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fileConfig is the format of the configuration file
+type fileConfig struct {
+	Webhooks []*webhookConfig `yaml:"webhooks"`
+}
+
+// webhookConfig contains the settings of a webhook, named after the per-webhook environment variables
+type webhookConfig struct {
+	Name           string   `yaml:"name"`
+	Secret         string   `yaml:"secret,omitempty"`
+	SecretFile     string   `yaml:"secretFile,omitempty"`
+	Auth           string   `yaml:"auth,omitempty"`
+	AuthFile       string   `yaml:"authFile,omitempty"`
+	AuthFailFast   bool     `yaml:"authFailFast,omitempty"`
+	ECR            bool     `yaml:"ecr,omitempty"`
+	RemoveOld      bool     `yaml:"removeOld,omitempty"`
+	Prune          bool     `yaml:"prune,omitempty"`
+	MaxDuration    string   `yaml:"maxDuration,omitempty"`
+	Atomic         bool     `yaml:"atomic,omitempty"`
+	SwapDelay      string   `yaml:"swapDelay,omitempty"`
+	StopTimeout    *int     `yaml:"stopTimeout,omitempty"`
+	ZeroDowntime   bool     `yaml:"zeroDowntime,omitempty"`
+	Concurrency    int      `yaml:"concurrency,omitempty"`
+	HealthTimeout  string   `yaml:"healthTimeout,omitempty"`
+	HealthInterval string   `yaml:"healthInterval,omitempty"`
+	HealthBackoff  float64  `yaml:"healthBackoff,omitempty"`
+	HealthRollback bool     `yaml:"healthRollback,omitempty"`
+	Stabilize      string   `yaml:"stabilize,omitempty"`
+	AllowResources []string `yaml:"allowResources,omitempty"`
+	AllowPorts     string   `yaml:"allowPorts,omitempty"`
+	Restarting     string   `yaml:"restarting,omitempty"`
+	Stamp          bool     `yaml:"stamp,omitempty"`
+	Resume         bool     `yaml:"resume,omitempty"`
+	Force          bool     `yaml:"force,omitempty"`
+	CosignKey      string   `yaml:"cosignKey,omitempty"`
+	AdoptDefaults  bool     `yaml:"adoptDefaults,omitempty"`
+	Platform       string   `yaml:"platform,omitempty"`
+	Selector       []string `yaml:"selector,omitempty"`
+	Containers     []string `yaml:"containers,omitempty"`
+	Methods        []string `yaml:"methods,omitempty"`
+	MatchExpr      string   `yaml:"matchExpr,omitempty"`
+	ConflictMode   string   `yaml:"conflictMode,omitempty"`
+	Mode           string   `yaml:"mode,omitempty"`
+	QueueTimeout   string   `yaml:"queueTimeout,omitempty"`
+	Async          bool     `yaml:"async,omitempty"`
+	PollInterval   string   `yaml:"pollInterval,omitempty"`
+	WatchPath      string   `yaml:"watchPath,omitempty"`
+	MTLS           []string `yaml:"mtls,omitempty"`
+	Signature      string   `yaml:"signature,omitempty"`
+	Cascade        bool     `yaml:"cascade,omitempty"`
+	AuditMode      bool     `yaml:"auditMode,omitempty"`
+	DryRun         bool     `yaml:"dryRun,omitempty"`
+	Slack          string   `yaml:"slack,omitempty"`
+	NotifyURL      string   `yaml:"notifyURL,omitempty"`
+}
+
+// get returns the setting of the per-webhook environment variable prefix as it would be set in the environment
+func (w *webhookConfig) get(prefix, _ string) string {
+	flag := func(b bool) string {
+		if b {
+			return "true"
+		}
+		return ""
+	}
+	switch prefix {
+	case EnvSecretPrefix:
+		return strings.TrimSpace(w.Secret)
+	case EnvSecretFilePrefix:
+		return strings.TrimSpace(w.SecretFile)
+	case EnvAuthPrefix:
+		return w.Auth
+	case EnvAuthFilePrefix:
+		return strings.TrimSpace(w.AuthFile)
+	case EnvAuthFailFastPrefix:
+		return flag(w.AuthFailFast)
+	case EnvECRPrefix:
+		return flag(w.ECR)
+	case EnvRemovePrefix:
+		return flag(w.RemoveOld)
+	case EnvPrunePrefix:
+		return flag(w.Prune)
+	case EnvMaxDurPrefix:
+		return w.MaxDuration
+	case EnvAtomicPrefix:
+		return flag(w.Atomic)
+	case EnvSwapDelayPrefix:
+		return w.SwapDelay
+	case EnvZeroDowntimePrefix:
+		return flag(w.ZeroDowntime)
+	case EnvConcurrencyPrefix:
+		if w.Concurrency == 0 {
+			return ""
+		}
+		return strconv.Itoa(w.Concurrency)
+	case EnvStopTimeoutPrefix:
+		if w.StopTimeout == nil {
+			return ""
+		}
+		return strconv.Itoa(*w.StopTimeout)
+	case EnvHealthTimeoutPrefix:
+		return w.HealthTimeout
+	case EnvHealthIntervalPrefix:
+		return w.HealthInterval
+	case EnvHealthBackoffPrefix:
+		if w.HealthBackoff == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(w.HealthBackoff, 'f', -1, 64)
+	case EnvHealthRollbackPrefix:
+		return flag(w.HealthRollback)
+	case EnvStabilizePrefix:
+		return w.Stabilize
+	case EnvAllowResPrefix:
+		return strings.Join(w.AllowResources, ",")
+	case EnvAllowPortsPrefix:
+		return w.AllowPorts
+	case EnvRestartingPrefix:
+		return w.Restarting
+	case EnvStampPrefix:
+		return flag(w.Stamp)
+	case EnvResumePrefix:
+		return flag(w.Resume)
+	case EnvForcePrefix:
+		return flag(w.Force)
+	case EnvCosignKeyPrefix:
+		return w.CosignKey
+	case EnvAdoptPrefix:
+		return flag(w.AdoptDefaults)
+	case EnvPlatformPrefix:
+		return w.Platform
+	case EnvSelectorPrefix:
+		return strings.Join(w.Selector, ",")
+	case EnvContainersPrefix:
+		return strings.Join(w.Containers, ",")
+	case EnvMethodsPrefix:
+		return strings.Join(w.Methods, ",")
+	case EnvMatchExprPrefix:
+		return w.MatchExpr
+	case EnvConflictPrefix:
+		return w.ConflictMode
+	case EnvModePrefix:
+		return w.Mode
+	case EnvQueueTimeoutPrefix:
+		return w.QueueTimeout
+	case EnvAsyncPrefix:
+		return flag(w.Async)
+	case EnvPollIntervalPrefix:
+		return w.PollInterval
+	case EnvWatchPathPrefix:
+		return w.WatchPath
+	case EnvMTLSPrefix:
+		return strings.Join(w.MTLS, ",")
+	case EnvSignaturePrefix:
+		return w.Signature
+	case EnvCascadePrefix:
+		return flag(w.Cascade)
+	case EnvAuditPrefix:
+		return flag(w.AuditMode)
+	case EnvDryRunPrefix:
+		return flag(w.DryRun)
+	case EnvSlackPrefix:
+		return w.Slack
+	case EnvNotifyURLPrefix:
+		return w.NotifyURL
+	}
+	return ""
+}
+
+// loadConfigFile reads the webhooks of the configuration file at path into attrs.
+// Webhooks defined in the environment take precedence
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg fileConfig
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	for i, w := range cfg.Webhooks {
+		if w == nil {
+			continue
+		}
+		name := strings.TrimSpace(w.Name)
+		if name == "" {
+			return fmt.Errorf("webhook #%d has no name", i+1)
+		}
+		if _, ok := attrs[name]; ok {
+			log.Infof("Webhook %s is defined in the environment, ignoring %s", name, path)
+			continue
+		}
+		if a := parseWebhook(name, w.get); a != nil {
+			attrs[name] = a
+			log.Infof("Loaded webhook %s from %s", name, path)
+		}
+	}
+	return nil
+}
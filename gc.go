@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"sync"
+	"time"
+)
+
+// gcResult is the result of a garbage collection run
+type gcResult struct {
+	At                time.Time `json:"at"`
+	ImagesDeleted     int       `json:"images_deleted"`
+	SpaceReclaimed    uint64    `json:"space_reclaimed"`
+	ContainersRemoved int       `json:"containers_removed"`
+	Error             string    `json:"error,omitempty"`
+}
+
+var (
+	// gcContainers enables removing exited / dead containers labeled for yadwh
+	gcContainers bool
+
+	lastGC   *gcResult
+	lastGCMu sync.Mutex
+)
+
+// runGC prunes dangling images and, if enabled, dead containers managed by yadwh
+func runGC(dctx context.Context) (res gcResult) {
+	res.At = time.Now()
+	if gcContainers {
+		list, err := dc.ContainerList(dctx, types.ContainerListOptions{
+			All: true,
+			Filters: filters.NewArgs(
+				filters.Arg("label", LabelKey),
+				filters.Arg("status", "exited"),
+				filters.Arg("status", "dead"),
+			),
+		})
+		if err != nil {
+			res.Error = err.Error()
+			return
+		}
+		for _, cont := range list {
+			// containers being updated are left alone
+			unlock, ok := containerLocks.tryLock(containerKey(cont.Names, cont.ID), "gc")
+			if !ok {
+				log.Debugf("GC: Skipping container %s, it's being updated", trimID(cont.ID))
+				continue
+			}
+			err = dc.ContainerRemove(dctx, cont.ID, types.ContainerRemoveOptions{})
+			unlock()
+			if err != nil {
+				log.WithError(err).Warnf("GC: Cannot remove container %s", trimID(cont.ID))
+				continue
+			}
+			res.ContainersRemoved++
+		}
+	}
+	report, err := pruneImages(dctx)
+	if err != nil {
+		res.Error = err.Error()
+		return
+	}
+	res.ImagesDeleted = len(report.ImagesDeleted)
+	res.SpaceReclaimed = report.SpaceReclaimed
+	return
+}
+
+// pruneImages removes all dangling images, images used by containers are kept by Docker
+func pruneImages(dctx context.Context) (types.ImagesPruneReport, error) {
+	// wait for running pulls and removals
+	imageGate.Lock()
+	defer imageGate.Unlock()
+	return dc.ImagesPrune(dctx, filters.NewArgs(filters.Arg("dangling", "true")))
+}
+
+// startGC runs the garbage collection every interval until shutdown
+func startGC(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shutdownCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			res := runGC(shutdownCtx)
+			if res.Error != "" {
+				log.Warnf("GC failed: %s", res.Error)
+			} else {
+				log.Infof("GC removed %d images (%d bytes) and %d containers",
+					res.ImagesDeleted, res.SpaceReclaimed, res.ContainersRemoved)
+			}
+			lastGCMu.Lock()
+			lastGC = &res
+			lastGCMu.Unlock()
+		}
+	}()
+}
+
+// lastGCResult returns the result of the last garbage collection or nil
+func lastGCResult() *gcResult {
+	lastGCMu.Lock()
+	defer lastGCMu.Unlock()
+	return lastGC
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"time"
+)
+
+// defaultHealthInterval is the default interval between two health checks during the readiness wait
+const defaultHealthInterval = time.Second
+
+// maxHealthInterval caps the interval if a backoff is configured
+const maxHealthInterval = 30 * time.Second
+
+// errUnhealthy is returned if a container became unhealthy during the readiness wait
+var errUnhealthy = errors.New("container is unhealthy")
+
+// waitHealthy polls the health of the container id until it's healthy or the health timeout elapsed.
+// The interval between two polls starts at healthInterval and is multiplied by healthBackoff after each poll.
+// Containers without a healthcheck are not waited for
+func (a *attributes) waitHealthy(dctx context.Context, id string) (err error) {
+	dctx, cancel := context.WithTimeout(dctx, a.healthTimeout)
+	defer cancel()
+
+	interval := a.healthInterval
+	for {
+		var inspect types.ContainerJSON
+		if inspect, err = dc.ContainerInspect(dctx, id); err != nil {
+			return
+		}
+		if inspect.State == nil || inspect.State.Health == nil {
+			log.Debugf("Container %s has no healthcheck", trimID(id))
+			return nil
+		}
+		switch inspect.State.Health.Status {
+		case types.Healthy:
+			return nil
+		case types.Unhealthy:
+			return errUnhealthy
+		}
+		if !inspect.State.Running {
+			return fmt.Errorf("container is %s", inspect.State.Status)
+		}
+
+		log.Debugf("Container %s is %s, checking again in %s", trimID(id), inspect.State.Health.Status, interval)
+		select {
+		case <-dctx.Done():
+			return fmt.Errorf("container not healthy after %s", a.healthTimeout)
+		case <-time.After(interval):
+		}
+		if a.healthBackoff > 1 {
+			if interval = time.Duration(float64(interval) * a.healthBackoff); interval > maxHealthInterval {
+				interval = maxHealthInterval
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/apex/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyContainer is a container touched by an update
+type historyContainer struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// OldImageID and NewImageID are the images before and after an update, set if the container was re-created
+	OldImageID string `json:"old_image_id,omitempty"`
+	NewImageID string `json:"new_image_id,omitempty"`
+}
+
+// historyEntry is an update or audit in the history of a webhook
+type historyEntry struct {
+	runRecord
+	// Audit is true if the entry is an audit, see attributes.audit. Drifted is the amount of drifted containers
+	Audit      bool               `json:"audit,omitempty"`
+	Drifted    int                `json:"drifted,omitempty"`
+	Containers []historyContainer `json:"containers"`
+}
+
+var (
+	// historySize is the amount of updates kept per webhook
+	historySize = 50
+	// historyRetention is the time updates are kept, 0 = only limited by historySize
+	historyRetention time.Duration
+	// historyFile persists the history if set
+	historyFile string
+
+	history   = make(map[string][]*historyEntry)
+	historyMu sync.Mutex
+	// historyFileMu serializes writes of historyFile
+	historyFileMu sync.Mutex
+)
+
+// recordHistory adds the update rec of the webhook name to its history
+func recordHistory(name string, rec *runRecord, result *UpdateResult) {
+	e := &historyEntry{runRecord: *rec, Containers: []historyContainer{}}
+	if result != nil {
+		restarted := make(map[string]restartedContainer, len(result.Restarted))
+		for _, r := range result.Restarted {
+			restarted[r.ID] = r
+		}
+		for _, o := range result.Containers {
+			c := historyContainer{ID: o.ID, Name: o.Name, Action: o.Action, Reason: o.Reason}
+			if r, ok := restarted[o.ID]; ok {
+				c.OldImageID, c.NewImageID = r.ImageID, r.NewImageID
+			}
+			e.Containers = append(e.Containers, c)
+		}
+	}
+	addHistory(name, e)
+}
+
+// recordAudit adds the audit res of the webhook name to its history
+func recordAudit(name, requestID string, res *auditResult) {
+	e := &historyEntry{
+		runRecord:  runRecord{At: res.At, RequestID: requestID, Status: fiber.StatusOK},
+		Audit:      true,
+		Drifted:    res.Drifted,
+		Containers: []historyContainer{},
+	}
+	for _, c := range res.Containers {
+		hc := historyContainer{ID: c.ID, Name: containerKey(c.Names, c.ID), Action: ActionCurrent}
+		switch {
+		case c.Error != "":
+			hc.Action, hc.Reason = ActionFailed, FailAuditCheck
+		case c.Drift:
+			hc.Action = ActionDrifted
+		}
+		e.Containers = append(e.Containers, hc)
+	}
+	addHistory(name, e)
+}
+
+// addHistory adds e to the history of the webhook name,
+// the oldest entry is dropped once historySize is exceeded
+func addHistory(name string, e *historyEntry) {
+	// name may point into a request
+	name = utils.CopyString(name)
+	historyMu.Lock()
+	h := append(history[name], e)
+	if len(h) > historySize {
+		h = h[len(h)-historySize:]
+	}
+	history[name] = h
+	historyMu.Unlock()
+
+	if historyFile != "" {
+		if err := saveHistory(historyFile); err != nil {
+			log.WithError(err).Warnf("Cannot write history to %s", historyFile)
+		}
+	}
+}
+
+// sweepHistory purges updates older than historyRetention
+func sweepHistory(now time.Time) (remaining int) {
+	if historyRetention <= 0 {
+		historyMu.Lock()
+		defer historyMu.Unlock()
+		for _, h := range history {
+			remaining += len(h)
+		}
+		return
+	}
+	purged := false
+	historyMu.Lock()
+	for name, h := range history {
+		i := 0
+		for i < len(h) && now.Sub(h[i].At) > historyRetention {
+			i++
+		}
+		if i == len(h) {
+			delete(history, name)
+		} else {
+			history[name] = h[i:]
+			remaining += len(h) - i
+		}
+		purged = purged || i > 0
+	}
+	historyMu.Unlock()
+
+	if purged && historyFile != "" {
+		if err := saveHistory(historyFile); err != nil {
+			log.WithError(err).Warnf("Cannot write history to %s", historyFile)
+		}
+	}
+	return
+}
+
+// saveHistory writes the history of all webhooks to path
+func saveHistory(path string) error {
+	historyFileMu.Lock()
+	defer historyFileMu.Unlock()
+	historyMu.Lock()
+	data, err := json.Marshal(history)
+	historyMu.Unlock()
+	if err != nil {
+		return err
+	}
+	// replace the file at once, so it's never read half-written
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadHistory reads the history written by saveHistory, a missing file is not an error
+func loadHistory(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string][]*historyEntry)
+	if err = json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	for name, h := range loaded {
+		if len(h) > historySize {
+			h = h[len(h)-historySize:]
+		}
+		history[name] = h
+	}
+	return nil
+}
+
+// handleHistory returns the recent updates of the webhook, oldest first
+func handleHistory(ctx *fiber.Ctx) error {
+	name := strings.TrimSpace(ctx.Params("name"))
+	if _, ok := attrs[name]; !ok {
+		return ErrWebhookNotFound
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	res := make([]*historyEntry, len(history[name]))
+	copy(res, history[name])
+	return ctx.JSON(res)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useHistory replaces the history of all webhooks until the test finished
+func useHistory(t *testing.T) {
+	prev, prevFile := history, historyFile
+	history, historyFile = make(map[string][]*historyEntry), ""
+	t.Cleanup(func() {
+		historyMu.Lock()
+		history, historyFile = prev, prevFile
+		historyMu.Unlock()
+	})
+}
+
+func TestRecordAudit(t *testing.T) {
+	useHistory(t)
+	recordAudit("WEB", "req1", &auditResult{
+		Webhook: "WEB",
+		At:      time.Now(),
+		Drifted: 1,
+		Containers: []auditedContainer{
+			{ID: "a", Names: []string{"/api"}},
+			{ID: "b", Names: []string{"/db"}, Drift: true},
+			{ID: "c", Names: []string{"/cache"}, Error: "registry unavailable"},
+		},
+	})
+
+	h := history["WEB"]
+	if len(h) != 1 {
+		t.Fatalf("expected the audit in the history, got %d entries", len(h))
+	}
+	if e := h[0]; !e.Audit || e.Drifted != 1 || e.RequestID != "req1" {
+		t.Errorf("expected an audit of req1 with 1 drifted container, got %+v", e)
+	}
+	want := map[string]string{"/api": ActionCurrent, "/db": ActionDrifted, "/cache": ActionFailed}
+	for _, c := range h[0].Containers {
+		if c.Action != want[c.Name] {
+			t.Errorf("expected %s to be %s, got %s", c.Name, want[c.Name], c.Action)
+		}
+	}
+}
+
+func TestSweepHistory(t *testing.T) {
+	useHistory(t)
+	prev := historyRetention
+	t.Cleanup(func() { historyRetention = prev })
+
+	now := time.Now()
+	entry := func(age time.Duration) *historyEntry {
+		return &historyEntry{runRecord: runRecord{At: now.Add(-age)}}
+	}
+	history["OLD"] = []*historyEntry{entry(3 * time.Hour), entry(2 * time.Hour)}
+	history["WEB"] = []*historyEntry{entry(2 * time.Hour), entry(30 * time.Minute), entry(time.Minute)}
+
+	// without retention, only the history size limits the history
+	historyRetention = 0
+	if remaining := sweepHistory(now); remaining != 5 {
+		t.Errorf("expected all 5 entries to be kept, got %d", remaining)
+	}
+
+	historyRetention = time.Hour
+	if remaining := sweepHistory(now); remaining != 2 {
+		t.Errorf("expected 2 remaining entries, got %d", remaining)
+	}
+	if _, ok := history["OLD"]; ok {
+		t.Error("expected the history of OLD to be purged")
+	}
+	if h := history["WEB"]; len(h) != 2 || h[0].At != now.Add(-30*time.Minute) {
+		t.Errorf("expected the 2 recent entries of WEB, got %+v", h)
+	}
+}
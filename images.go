@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// runningImage is the image a container is currently running
+type runningImage struct {
+	ID          string   `json:"id"`
+	Names       []string `json:"names"`
+	Image       string   `json:"image"`
+	ImageID     string   `json:"image_id"`
+	RepoDigests []string `json:"repo_digests"`
+}
+
+// images returns the images of all containers matched by a webhook,
+// using local information only
+func images(name, secret string, ctx *fiber.Ctx) (err error) {
+	name = strings.TrimSpace(name)
+	if _, err = authorize(name, strings.TrimSpace(secret)); err != nil {
+		return
+	}
+
+	var containerList []types.Container
+	if containerList, err = matchingContainers(ctx.Context(), name); err != nil {
+		return fiber.NewError(500, err.Error())
+	}
+
+	result := make([]runningImage, 0, len(containerList))
+	inspected := make(map[string][]string) // image id -> repo digests
+	for _, cont := range containerList {
+		digests, ok := inspected[cont.ImageID]
+		if !ok {
+			var img types.ImageInspect
+			if img, _, err = dc.ImageInspectWithRaw(ctx.Context(), cont.ImageID); err != nil {
+				log.WithError(err).Warnf("Cannot inspect image %s", trimID(cont.ImageID))
+			}
+			digests = img.RepoDigests
+			inspected[cont.ImageID] = digests
+		}
+		result = append(result, runningImage{
+			ID:          cont.ID,
+			Names:       cont.Names,
+			Image:       cont.Image,
+			ImageID:     cont.ImageID,
+			RepoDigests: digests,
+		})
+	}
+	return ctx.JSON(result)
+}
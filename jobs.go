@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// states of a job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+var (
+	// jobRetention is the time finished jobs are kept
+	jobRetention = time.Hour
+	// maxJobs is the amount of jobs kept, the oldest finished jobs are dropped first
+	maxJobs = 100
+)
+
+// job is an update running in the background
+type job struct {
+	ID        string        `json:"id"`
+	RequestID string        `json:"request_id"`
+	Webhook   string        `json:"webhook"`
+	State     string        `json:"state"`
+	Created   time.Time     `json:"created"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+	Status    int           `json:"status,omitempty"`
+	Result    *UpdateResult `json:"result,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+var (
+	jobs   = make(map[string]*job)
+	jobsMu sync.Mutex
+)
+
+// newJob creates a queued job of the webhook for the request requestID
+func newJob(webhook, requestID string) *job {
+	j := &job{ID: randomID(8), RequestID: requestID, Webhook: webhook, State: JobQueued, Created: time.Now()}
+	jobsMu.Lock()
+	jobs[j.ID] = j
+	trimJobs()
+	jobsMu.Unlock()
+	return j
+}
+
+// trimJobs drops the oldest finished jobs until at most maxJobs are kept, jobsMu has to be held.
+// Queued and running jobs are never dropped
+func trimJobs() {
+	for len(jobs) > maxJobs {
+		var oldest *job
+		for _, j := range jobs {
+			if j.Finished != nil && (oldest == nil || j.Finished.Before(*oldest.Finished)) {
+				oldest = j
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(jobs, oldest.ID)
+	}
+}
+
+// setState changes the state of j
+func (j *job) setState(state string) {
+	jobsMu.Lock()
+	j.State = state
+	jobsMu.Unlock()
+}
+
+// finish stores the result or error of j
+func (j *job) finish(result *UpdateResult, err error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	now := time.Now()
+	j.Finished = &now
+	if err != nil {
+		j.State, j.Error = JobFailed, err.Error()
+		j.Status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			j.Status = e.Code
+		}
+		return
+	}
+	j.State, j.Status, j.Result = JobDone, result.status(), result
+}
+
+// jobOf returns a copy of the job id of the webhook
+func jobOf(webhook, id string) (job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	if !ok || j.Webhook != webhook {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// sweepJobs purges jobs finished more than jobRetention ago
+func sweepJobs(now time.Time) int {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for id, j := range jobs {
+		if j.Finished != nil && now.Sub(*j.Finished) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+	return len(jobs)
+}
+
+// enqueue runs the update in a background job and answers with 202 and the job.
+// If locked is false, the job waits for the update lock of the webhook, which is released once the job finished.
+// The job untracks the update
+func (u *updateRun) enqueue(ctx *fiber.Ctx, locked bool) error {
+	// the name may point into the request, which is reused after the handler returned
+	u.name = utils.CopyString(u.name)
+	j := newJob(u.name, u.requestID)
+	u.logger.Infof("Update queued as job %s", j.ID)
+	go func() {
+		defer u.untrack()
+		if !locked {
+			lctx, cancel := shutdownCtx, context.CancelFunc(func() {})
+			if u.expected.queueTimeout > 0 {
+				lctx, cancel = context.WithTimeout(lctx, u.expected.queueTimeout)
+			}
+			err := u.expected.lock.lock(lctx)
+			cancel()
+			if err != nil {
+				u.logger.WithError(err).Warnf("Job %s gave up waiting for the running update", j.ID)
+				j.finish(nil, fiber.NewError(fiber.StatusTooManyRequests,
+					fmt.Sprintf("gave up waiting for running update: %v", err)))
+				return
+			}
+		}
+		defer u.expected.lock.unlock()
+		j.setState(JobRunning)
+		result, err := u.run()
+		j.finish(result, err)
+		u.logger.Infof("Job %s finished", j.ID)
+	}()
+	snapshot, _ := jobOf(u.name, j.ID)
+	return ctx.Status(fiber.StatusAccepted).JSON(snapshot)
+}
+
+// handleJob returns the job id of the webhook name
+func handleJob(name, secret string, ctx *fiber.Ctx) error {
+	name = strings.TrimSpace(name)
+	if _, err := authorize(name, strings.TrimSpace(secret)); err != nil {
+		return err
+	}
+	j, ok := jobOf(name, ctx.Params("id"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "job not found")
+	}
+	return ctx.JSON(j)
+}
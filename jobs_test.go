@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useJobs replaces all jobs until the test finished
+func useJobs(t *testing.T) {
+	prev, prevRetention, prevMax := jobs, jobRetention, maxJobs
+	jobs = make(map[string]*job)
+	t.Cleanup(func() {
+		jobsMu.Lock()
+		jobs, jobRetention, maxJobs = prev, prevRetention, prevMax
+		jobsMu.Unlock()
+	})
+}
+
+func TestSweepJobs(t *testing.T) {
+	useJobs(t)
+	jobRetention = time.Hour
+	now := time.Now()
+	finished := func(age time.Duration) *time.Time {
+		at := now.Add(-age)
+		return &at
+	}
+	jobs["old"] = &job{ID: "old", State: JobDone, Finished: finished(2 * time.Hour)}
+	jobs["recent"] = &job{ID: "recent", State: JobDone, Finished: finished(time.Minute)}
+	jobs["running"] = &job{ID: "running", State: JobRunning, Created: now.Add(-3 * time.Hour)}
+
+	if remaining := sweepJobs(now); remaining != 2 {
+		t.Errorf("expected 2 remaining jobs, got %d", remaining)
+	}
+	if _, ok := jobs["old"]; ok {
+		t.Error("expected the old job to be purged")
+	}
+	if _, ok := jobs["running"]; !ok {
+		t.Error("expected the running job to be kept")
+	}
+}
+
+func TestMaxJobs(t *testing.T) {
+	useJobs(t)
+	maxJobs = 2
+
+	first := newJob("WEB", "req1")
+	first.finish(&UpdateResult{}, nil)
+	time.Sleep(time.Millisecond)
+	second := newJob("WEB", "req2")
+	second.finish(&UpdateResult{}, nil)
+	queued := newJob("WEB", "req3")
+
+	// the count cap applies before the retention
+	if _, ok := jobOf("WEB", first.ID); ok {
+		t.Error("expected the oldest finished job to be dropped")
+	}
+	for _, j := range []*job{second, queued} {
+		if _, ok := jobOf("WEB", j.ID); !ok {
+			t.Errorf("expected job %s to be kept", j.RequestID)
+		}
+	}
+
+	// unfinished jobs are never dropped
+	newJob("WEB", "req4")
+	if _, ok := jobOf("WEB", queued.ID); !ok {
+		t.Error("expected the queued job to be kept")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"io"
+	"strings"
+	"time"
+)
+
+// localLoaded is the fiber local containing the normalized references of images loaded from a tarball
+const localLoaded = "yadwh-loaded"
+
+// maxLoadSize is the maximum size of an image tarball
+var maxLoadSize int64 = 2 << 30
+
+// maxBodySize is the maximum size of all other request bodies
+var maxBodySize int64 = 1 << 20
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+// limitBody rejects requests with a body larger than limit with 413.
+// It's required on every route but the upload of image tarballs, since request bodies are streamed to support them
+func limitBody(limit int64) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		// the rest of the body is never read, so the connection can't be reused
+		if int64(ctx.Request().Header.ContentLength()) > limit {
+			ctx.Context().SetConnectionClose()
+			return fiber.ErrRequestEntityTooLarge
+		}
+		// chunked bodies don't announce their size, read them up to the limit
+		if stream := ctx.Context().RequestBodyStream(); stream != nil && ctx.Request().Header.ContentLength() < 0 {
+			body, err := io.ReadAll(io.LimitReader(stream, limit+1))
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "cannot read body: "+err.Error())
+			}
+			if int64(len(body)) > limit {
+				ctx.Context().SetConnectionClose()
+				return fiber.ErrRequestEntityTooLarge
+			}
+			ctx.Request().SetBody(body)
+		}
+		return ctx.Next()
+	}
+}
+
+// load loads an image tarball from the request body and re-creates
+// all matched containers using one of the loaded images
+func load(name, secret string, ctx *fiber.Ctx) (err error) {
+	name = utils.CopyString(strings.TrimSpace(name))
+	secret = strings.TrimSpace(secret)
+	if _, err = authorize(name, secret); err != nil {
+		return
+	}
+	if isPaused() {
+		return ErrPaused
+	}
+	if ctx.Request().Header.ContentLength() > int(maxLoadSize) {
+		return fiber.ErrRequestEntityTooLarge
+	}
+	// large tarballs take longer than the read timeout to upload
+	if err = ctx.Context().Conn().SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	var stream io.Reader = ctx.Context().RequestBodyStream()
+	if stream == nil {
+		stream = bytes.NewReader(ctx.Body())
+	}
+	counter := &countingReader{r: io.LimitReader(stream, maxLoadSize+1)}
+
+	log.Infof("Loading image tarball for %s", name)
+	var resp types.ImageLoadResponse
+	if resp, err = dc.ImageLoad(ctx.Context(), counter, true); err != nil {
+		if counter.n > maxLoadSize {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		return fiber.NewError(fiber.StatusBadRequest, "cannot load image: "+err.Error())
+	}
+	defer resp.Body.Close()
+
+	// find loaded images in response
+	loaded := make(map[string]bool)
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err = dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fiber.NewError(500, "cannot read load response: "+err.Error())
+		}
+		if msg.Error != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "cannot load image: "+msg.Error.Message)
+		}
+		if ref := strings.TrimSpace(strings.TrimPrefix(msg.Stream, "Loaded image: ")); ref != strings.TrimSpace(msg.Stream) {
+			log.Infof("Loaded image %s", ref)
+			loaded[normalizeRef(ref)] = true
+		}
+	}
+	if len(loaded) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "tarball contains no tagged image")
+	}
+
+	ctx.Locals(localLoaded, loaded)
+	return process(name, secret, ctx)
+}
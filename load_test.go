@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLimitBody(t *testing.T) {
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
+	limit := limitBody(16)
+	// like the routes of main, the upload of tarballs is not limited
+	app.Post("/:name/:secret/load", func(ctx *fiber.Ctx) error {
+		n, err := io.Copy(io.Discard, ctx.Context().RequestBodyStream())
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(n)
+	})
+	app.Post("/:name", limit, func(ctx *fiber.Ctx) error {
+		return ctx.JSON(len(ctx.Body()))
+	})
+
+	small, large := strings.Repeat("a", 16), strings.Repeat("a", 17)
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{"within limit", "/web", small, false, fiber.StatusOK},
+		{"content-length over limit", "/web", large, false, fiber.StatusRequestEntityTooLarge},
+		{"chunked within limit", "/web", small, true, fiber.StatusOK},
+		{"chunked over limit", "/web", large, true, fiber.StatusRequestEntityTooLarge},
+		{"webhook called load", "/load", large, false, fiber.StatusRequestEntityTooLarge},
+		{"tarball upload", "/web/secret/load", large, false, fiber.StatusOK},
+		{"chunked tarball upload", "/web/secret/load", large, true, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				return
+			}
+			// the handler has to see the whole body
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strconv.Itoa(len(tt.body)); string(got) != want {
+				t.Errorf("expected the handler to read %s bytes, got %s", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/moby/moby/client"
+	"os"
+	"strconv"
+	"time"
+)
+
+// instance lock modes
+const (
+	LockFail = "fail" // refuse to start if another instance holds the lock
+	LockWarn = "warn" // only warn if another instance holds the lock
+)
+
+// labels of the lock volume
+const (
+	LabelLockOwner = "io.d2a.yadwh.lock.owner"
+	LabelLockPID   = "io.d2a.yadwh.lock.pid"
+	LabelLockSince = "io.d2a.yadwh.lock.since"
+)
+
+var (
+	lockName  = "yadwh-lock" // name of the lock volume
+	lockOwner string         // hostname of this instance (container ID when running in Docker)
+	lockHeld  bool
+)
+
+// acquireLock claims the lock volume for this instance.
+// The volume is created with this instance as owner, if it already exists the owner is checked.
+// A lock of a container which is no longer running is taken over.
+func acquireLock(dctx context.Context) (err error) {
+	if lockOwner, err = os.Hostname(); err != nil {
+		return
+	}
+	pid := strconv.Itoa(os.Getpid())
+
+	var vol types.Volume
+	if vol, err = dc.VolumeCreate(dctx, volume.VolumeCreateBody{
+		Name: lockName,
+		Labels: map[string]string{
+			LabelLockOwner: lockOwner,
+			LabelLockPID:   pid,
+			LabelLockSince: time.Now().UTC().Format(time.RFC3339),
+		},
+	}); err != nil {
+		return
+	}
+
+	owner := vol.Labels[LabelLockOwner]
+	if owner == lockOwner {
+		// either we created the volume or it's left over from a previous run of this container
+		lockHeld = true
+		return nil
+	}
+
+	// take over stale lock
+	var inspect types.ContainerJSON
+	if inspect, err = dc.ContainerInspect(dctx, owner); err == nil && inspect.State != nil && !inspect.State.Running {
+		log.Warnf("Taking over lock of stopped instance %s", trimID(owner))
+		if err = dc.VolumeRemove(dctx, lockName, true); err != nil {
+			return
+		}
+		return acquireLock(dctx)
+	}
+	if err != nil && !client.IsErrNotFound(err) {
+		return
+	}
+	return fmt.Errorf("lock %s is held by %s (pid %s, since %s), remove the volume if the instance is gone",
+		lockName, owner, vol.Labels[LabelLockPID], vol.Labels[LabelLockSince])
+}
+
+// releaseLock removes the lock volume if it's held by this instance
+func releaseLock() {
+	if !lockHeld {
+		return
+	}
+	if err := dc.VolumeRemove(context.Background(), lockName, true); err != nil {
+		log.WithError(err).Warn("Cannot release instance lock")
+		return
+	}
+	lockHeld = false
+	log.Info("Released instance lock")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"github.com/apex/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhooks and background operations (pre-warming, GC) coordinate with the following locks.
+// To avoid deadlocks they are always acquired in this order and released in reverse:
+//
+//  1. the container lock of the container being changed, at most one at a time
+//  2. the image lock of the image reference being pulled or removed
+//  3. the image gate, held shared by image locks and exclusively while pruning images
+//
+// Updates of a webhook are serialized by its updateLock before any of these are acquired.
+
+// keyedLock is a lock on a single key
+type keyedLock struct {
+	*updateLock
+	refs   int    // amount of holders and waiters
+	holder string // operation holding the lock
+}
+
+// keyedLocks serializes operations on the same key while operations on different keys proceed in parallel
+type keyedLocks struct {
+	mu        sync.Mutex
+	locks     map[string]*keyedLock
+	contended uint64 // acquisitions which had to wait for another operation
+}
+
+func newKeyedLocks() *keyedLocks {
+	return &keyedLocks{locks: make(map[string]*keyedLock)}
+}
+
+var (
+	containerLocks = newKeyedLocks() // keyed by container name
+	imageLocks     = newKeyedLocks() // keyed by normalized image reference
+
+	// imageGate is held shared by image locks and exclusively while pruning images
+	imageGate sync.RWMutex
+)
+
+func (k *keyedLocks) get(key string) *keyedLock {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{updateLock: newUpdateLock()}
+		k.locks[key] = l
+	}
+	l.refs++
+	return l
+}
+
+func (k *keyedLocks) release(key string, l *keyedLock) {
+	k.mu.Lock()
+	if l.refs--; l.refs == 0 {
+		delete(k.locks, key)
+	}
+	k.mu.Unlock()
+}
+
+func (k *keyedLocks) acquired(key string, l *keyedLock, holder string) func() {
+	k.mu.Lock()
+	l.holder = holder
+	k.mu.Unlock()
+	return func() {
+		k.mu.Lock()
+		l.holder = ""
+		k.mu.Unlock()
+		l.unlock()
+		k.release(key, l)
+	}
+}
+
+// lock waits until key is locked by holder or dctx is done.
+// The returned function releases the lock
+func (k *keyedLocks) lock(dctx context.Context, key, holder string) (unlock func(), err error) {
+	l := k.get(key)
+	if !l.tryLock() {
+		atomic.AddUint64(&k.contended, 1)
+		log.Debugf("%s is waiting for %s", holder, key)
+		if err = l.lock(dctx); err != nil {
+			k.release(key, l)
+			return nil, err
+		}
+	}
+	return k.acquired(key, l, holder), nil
+}
+
+// tryLock locks key by holder if it's not locked
+func (k *keyedLocks) tryLock(key, holder string) (unlock func(), ok bool) {
+	l := k.get(key)
+	if !l.tryLock() {
+		k.release(key, l)
+		return nil, false
+	}
+	return k.acquired(key, l, holder), true
+}
+
+// lockHolder is an operation holding a lock
+type lockHolder struct {
+	Key    string    `json:"key"`
+	Holder string    `json:"holder"`
+	Since  time.Time `json:"since"`
+}
+
+// lockStats contains the usage of keyed locks
+type lockStats struct {
+	Contended uint64       `json:"contended"` // acquisitions which had to wait since the start
+	Holders   []lockHolder `json:"holders"`
+}
+
+// locksUsage contains the usage of container and image locks
+type locksUsage struct {
+	Containers lockStats `json:"containers"`
+	Images     lockStats `json:"images"`
+}
+
+func (k *keyedLocks) stats() lockStats {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	res := lockStats{Contended: atomic.LoadUint64(&k.contended), Holders: []lockHolder{}}
+	for key, l := range k.locks {
+		if l.holder == "" {
+			continue
+		}
+		res.Holders = append(res.Holders, lockHolder{Key: key, Holder: l.holder, Since: l.runningSince()})
+	}
+	sort.Slice(res.Holders, func(i, j int) bool {
+		return res.Holders[i].Key < res.Holders[j].Key
+	})
+	return res
+}
+
+// lockContainer waits until the container name is locked by holder
+func lockContainer(dctx context.Context, name, holder string) (unlock func(), err error) {
+	return containerLocks.lock(dctx, name, holder)
+}
+
+// lockImage waits until the image reference ref is locked by holder.
+// The returned function releases the lock
+func lockImage(dctx context.Context, ref, holder string) (unlock func(), err error) {
+	release, err := imageLocks.lock(dctx, normalizeRef(ref), holder)
+	if err != nil {
+		return nil, err
+	}
+	imageGate.RLock()
+	return func() {
+		imageGate.RUnlock()
+		release()
+	}, nil
+}
@@ -2,31 +2,167 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/antonmedv/expr/vm"
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/cli"
+	jsonlog "github.com/apex/log/handlers/json"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-units"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/moby/moby/client"
+	"github.com/prometheus/client_golang/prometheus"
 	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 // environment variable prefixes
 const (
-	EnvSecretPrefix = "WH_SECRET_"
-	EnvAuthPrefix   = "WH_AUTH_"
-	EnvRemovePrefix = "WH_REMOVE_"
-	LabelKey        = "io.d2a.yadwh.ug"
+	EnvSecretPrefix         = "WH_SECRET_"
+	EnvSecretFilePrefix     = "WH_SECRET_FILE_"
+	EnvAuthPrefix           = "WH_AUTH_"
+	EnvAuthFilePrefix       = "WH_AUTH_FILE_"
+	EnvRemovePrefix         = "WH_REMOVE_"
+	EnvPrunePrefix          = "WH_PRUNE_"
+	EnvMaxDurPrefix         = "WH_MAX_DURATION_"
+	EnvAtomicPrefix         = "WH_ATOMIC_"
+	EnvAllowResPrefix       = "WH_ALLOW_RESOURCES_"
+	EnvAllowPortsPrefix     = "WH_ALLOW_PORTS_"
+	EnvRestartingPrefix     = "WH_RESTARTING_"
+	EnvStampPrefix          = "WH_STAMP_"
+	EnvCosignKeyPrefix      = "WH_COSIGN_KEY_"
+	EnvSwapDelayPrefix      = "WH_SWAP_DELAY_"
+	EnvAdoptPrefix          = "WH_ADOPT_DEFAULTS_"
+	EnvSelectorPrefix       = "WH_SELECTOR_"
+	EnvPlatformPrefix       = "WH_PLATFORM_"
+	EnvContainersPrefix     = "WH_CONTAINERS_"
+	EnvMethodsPrefix        = "WH_METHODS_"
+	EnvConflictPrefix       = "WH_CONFLICT_MODE_"
+	EnvModePrefix           = "WH_MODE_"
+	EnvQueueTimeoutPrefix   = "WH_QUEUE_TIMEOUT_"
+	EnvHealthTimeoutPrefix  = "WH_HEALTH_TIMEOUT_"
+	EnvHealthIntervalPrefix = "WH_HEALTH_INTERVAL_"
+	EnvHealthBackoffPrefix  = "WH_HEALTH_BACKOFF_"
+	EnvHealthRollbackPrefix = "WH_HEALTH_ROLLBACK_"
+	EnvWatchPathPrefix      = "WH_WATCH_PATH_"
+	EnvMTLSPrefix           = "WH_MTLS_"
+	EnvCascadePrefix        = "WH_CASCADE_"
+	EnvStabilizePrefix      = "WH_STABILIZE_"
+	EnvMatchExprPrefix      = "WH_MATCH_EXPR_"
+	EnvResumePrefix         = "WH_RESUME_"
+	EnvForcePrefix          = "WH_FORCE_"
+	EnvDryRunPrefix         = "WH_DRYRUN_"
+	EnvSlackPrefix          = "WH_SLACK_"
+	EnvNotifyURLPrefix      = "WH_NOTIFY_URL_"
+	EnvAsyncPrefix          = "WH_ASYNC_"
+	EnvPollIntervalPrefix   = "WH_POLL_INTERVAL_"
+	EnvStopTimeoutPrefix    = "WH_STOP_TIMEOUT_"
+	EnvZeroDowntimePrefix   = "WH_ZERODOWNTIME_"
+	EnvAuthFailFastPrefix   = "WH_AUTH_FAIL_FAST_"
+	EnvECRPrefix            = "WH_ECR_"
+	EnvAuditPrefix          = "WH_AUDIT_MODE_"
+	EnvSignaturePrefix      = "WH_SIGNATURE_"
+	EnvConcurrencyPrefix    = "WH_CONCURRENCY_"
 )
 
+// modes of a webhook
+const (
+	ModeUpdate      = "update"       // pull images and re-create containers
+	ModePullOnly    = "pull-only"    // only pull images, containers keep running
+	ModeRestartOnly = "restart-only" // re-create containers from the local images
+)
+
+// DefaultLabelKey is the label listing the webhooks a container is updated by
+const DefaultLabelKey = "io.d2a.yadwh.ug"
+
+// LabelKey is the label listing the webhooks a container is updated by, see EnvLabelKey
+var LabelKey = DefaultLabelKey
+
+// labels to configure the update of a single container
+const (
+	LabelNoStart     = "io.d2a.yadwh.no-start"
+	LabelStopTimeout = "io.d2a.yadwh.stop-timeout"
+	LabelApproval    = "io.d2a.yadwh.approval"
+	LabelOrder       = "io.d2a.yadwh.order"
+)
+
+// labels added to re-created containers if stamping is enabled
+const (
+	LabelDeployedAt     = "io.d2a.yadwh.deployed-at"
+	LabelDeployedBy     = "io.d2a.yadwh.deployed-by"
+	LabelDeployedDigest = "io.d2a.yadwh.deployed-digest"
+)
+
+// LabelConfigHash contains the hash of the watched path a container was created with
+const LabelConfigHash = "io.d2a.yadwh.config-hash"
+
+// global environment variables
+const (
+	EnvIDLength          = "WH_ID_LEN"
+	EnvAdminToken        = "WH_ADMIN_TOKEN"
+	EnvLock              = "WH_LOCK"
+	EnvLockName          = "WH_LOCK_NAME"
+	EnvRetryAttempts     = "WH_RETRY_ATTEMPTS"
+	EnvRetryMaxDelay     = "WH_RETRY_MAX_DELAY"
+	EnvNotifyTimeout     = "WH_NOTIFY_TIMEOUT"
+	EnvAdminAddr         = "WH_ADMIN_ADDR"
+	EnvStartupDelay      = "WH_STARTUP_DELAY"
+	EnvDockerWait        = "WH_DOCKER_WAIT"
+	EnvEventURL          = "WH_EVENT_URL"
+	EnvEventSubject      = "WH_EVENT_SUBJECT"
+	EnvSecretSources     = "WH_GLOBAL_SECRET_SOURCES"
+	EnvMaxLoadSize       = "WH_MAX_LOAD_SIZE"
+	EnvMaxBodySize       = "WH_MAX_BODY_SIZE"
+	EnvHistorySize       = "WH_HISTORY_SIZE"
+	EnvHistoryFile       = "WH_HISTORY_FILE"
+	EnvHistoryRetention  = "WH_HISTORY_RETENTION"
+	EnvJobRetention      = "WH_JOB_RETENTION"
+	EnvMaxJobs           = "WH_MAX_JOBS"
+	EnvRollbackRetention = "WH_ROLLBACK_RETENTION"
+	EnvDockerConfig      = "WH_DOCKER_CONFIG"
+	EnvDockerConfigDir   = "DOCKER_CONFIG"
+	EnvApprovalTimeout   = "WH_APPROVAL_TIMEOUT"
+	EnvEmitReadyEvent    = "WH_EMIT_READY_EVENT"
+	EnvTLSCert           = "WH_TLS_CERT"
+	EnvTLSKey            = "WH_TLS_KEY"
+	EnvTLSClientCA       = "WH_TLS_CLIENT_CA"
+	EnvGCInterval        = "WH_GC_INTERVAL"
+	EnvGCContainers      = "WH_GC_CONTAINERS"
+	EnvPrewarm           = "WH_PREWARM"
+	EnvRegistryRPS       = "WH_REGISTRY_RPS"
+	EnvPort              = "WH_PORT"
+	EnvUnixSocket        = "WH_UNIX_SOCKET"
+	EnvUnixSocketMode    = "WH_UNIX_SOCKET_MODE"
+	EnvReadTimeout       = "WH_READ_TIMEOUT"
+	EnvWriteTimeout      = "WH_WRITE_TIMEOUT"
+	EnvIdleTimeout       = "WH_IDLE_TIMEOUT"
+	EnvConfig            = "WH_CONFIG"
+	EnvLogFormat         = "WH_LOG_FORMAT"
+	EnvLogLevel          = "WH_LOG_LEVEL"
+	EnvDrainTimeout      = "WH_DRAIN_TIMEOUT"
+	EnvAllowCIDR         = "WH_ALLOW_CIDR"
+	EnvTrustedProxies    = "WH_TRUSTED_PROXIES"
+	EnvLabelKey          = "WH_LABEL_KEY"
+)
+
+// HeaderRequestID contains the ID of a webhook request, which is logged as req
+const HeaderRequestID = "X-YADWH-Request-ID"
+
 // fiber errors
 var (
 	ErrSecretInvalid   = fiber.NewError(401, "secret mismatch")
@@ -35,118 +171,570 @@ var (
 
 // attributes contains label specific settings
 type attributes struct {
-	secret    string
-	auth      string // base64 encoded auth string
-	removeOld bool   // remove old image after pulling new
+	secret       string
+	auth         string // base64 encoded auth string
+	authFailFast bool   // abort the update after the registry denied access
+	ecr          bool   // request ECR tokens for images in ECR registries
+	removeOld    bool   // remove old image after pulling new
+	prune        bool   // prune dangling images once after the update instead
+
+	maxDuration  time.Duration // ceiling for a whole update, 0 = unlimited
+	atomic       bool          // restore all re-created containers if the update failed or was interrupted
+	swapDelay    time.Duration // delay between removing the old and creating the new container
+	zeroDowntime bool          // start the new container before stopping the old one if possible
+	concurrency  int           // containers updated in parallel, 1 = one after another
+	stopTimeout  time.Duration // time containers have to stop before they are killed, -1 = not set
+
+	healthTimeout  time.Duration // time to wait for a re-created container to become healthy, 0 = don't wait
+	healthInterval time.Duration // interval between two health checks
+	healthBackoff  float64       // factor the interval is multiplied by after each check
+	healthRollback bool          // restore the previous container if the new one doesn't become healthy
+	stabilization  time.Duration // time re-created containers have to keep running after the whole update, 0 = don't wait
+
+	allowResources map[string]bool // resource limits which may be changed by a request
+	allowPorts     []portRange     // host ports which may be published by a request
+	restarting     string          // handling of restarting containers
+	stamp          bool            // add deploy metadata labels to re-created containers
+	resume         bool            // skip containers already stamped with the current image
+	force          bool            // re-create containers even if their image didn't change
+	cosignKey      string          // public key to verify image signatures with
+	adoptDefaults  bool            // adopt changed entrypoint / cmd of new images if not overridden
+	platform       string          // platform images are pulled and containers created for (os/arch[/variant]), empty = host
+	selector       []string        // additional label filters (key or key=value)
+	containers     []string        // names of containers updated in addition to the labeled ones
+	methods        []string        // HTTP methods allowed to trigger the webhook, empty = all
+	matchExpr      string          // source of match
+	match          *vm.Program     // expression containers have to match, nil = all
+	conflictMode   string          // handling of triggers while an update is running
+	mode           string          // update, pull-only or restart-only
+	queueTimeout   time.Duration   // maximum wait for a running update in queue mode, 0 = unlimited
+	async          bool            // run updates as background jobs and answer with 202
+	pollInterval   time.Duration   // interval the registry is checked for new images in, 0 = only by webhook
+	watchPath      string          // only re-create containers if this file / directory or the image changed
+	mtls           []string        // client certificate subjects / SANs allowed to trigger without secret
+	signature      string          // only accept requests signed with the secret in this mode
+	cascade        bool            // restart containers listed in the triggers label of updated containers
+	audit          bool            // only report drift, never update
+	dryRun         bool            // only pull and report which containers would be updated
+	slackURL       string          // Slack incoming webhook notified about updates
+	notifyURL      string          // URL the result of each update is posted to
+
+	lock *updateLock
 }
 
+// version is set at build time
+var version = "dev"
+
 var (
-	attrs = make(map[string]*attributes)
-	dc    *client.Client
+	attrs    = make(map[string]*attributes)
+	dc       *client.Client
+	idLength = 12 // amount of characters displayed by trimID
+
+	// shutdownCtx is cancelled when the server shuts down
+	shutdownCtx, shutdown = context.WithCancel(context.Background())
 )
 
 func init() {
-	log.SetHandler(cli.Default)
-	log.SetLevel(log.DebugLevel)
+	switch format := strings.ToLower(strings.TrimSpace(os.Getenv(EnvLogFormat))); format {
+	case "", "cli":
+		log.SetHandler(cli.Default)
+	case "json":
+		log.SetHandler(jsonlog.Default)
+	default:
+		log.SetHandler(cli.Default)
+		log.Fatalf("Invalid %s: %s (expected cli or json)", EnvLogFormat, format)
+	}
+	level := log.DebugLevel
+	if v := strings.TrimSpace(os.Getenv(EnvLogLevel)); v != "" {
+		var err error
+		if level, err = log.ParseLevel(strings.ToLower(v)); err != nil {
+			log.Fatalf("Invalid %s: %s (expected debug, info, warn or error)", EnvLogLevel, v)
+		}
+	}
+	log.SetLevel(level)
 }
 
 func main() {
-	// Load secrets from env
-	for _, env := range os.Environ() {
-		if !strings.HasPrefix(env, EnvSecretPrefix) {
-			continue
-		}
-		key := env[:strings.Index(env, "=")]
-		name := key[len(EnvSecretPrefix):]
-		if len(name) == 0 {
-			log.Warnf("Empty secret name: %s", env)
-			continue
-		}
+	exportPath := flag.String("export-config", "", "write the loaded webhooks as YAML to this file and exit")
+	exportSecrets := flag.Bool("export-secrets", false, "include secrets and registry credentials in the exported configuration")
+	flag.Parse()
 
-		// find secret in env
-		sec := strings.TrimSpace(os.Getenv(key))
-		if len(sec) < 12 {
-			log.WithField("webhook", name).Warn("Secrets are required to be at least 12 chars long")
-			continue
+	var err error
+	// ID length used in logs
+	if v := strings.TrimSpace(os.Getenv(EnvIDLength)); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid %s: %s (expected a positive number)", EnvIDLength, v)
+			return
 		}
-		log.Infof("Found secret for %s = %s", name, strings.Repeat("*", len(sec)))
-
-		// find auth in env
-		auth := strings.TrimSpace(os.Getenv(EnvAuthPrefix + name))
-		log.Infof("auth secret for %s = %s", name, strings.Repeat("*", len(auth)))
+		idLength = n
+	}
+	loadRetryPolicy()
+	if v := strings.TrimSpace(os.Getenv(EnvSecretSources)); v != "" {
+		if secretSources, err = parseSecretSources(v); err != nil {
+			log.WithError(err).Fatalf("Invalid %s", EnvSecretSources)
+			return
+		}
+		log.Infof("Secrets are read from %s", strings.Join(secretSources, ", "))
+	}
 
-		// find remove old images
-		removeOld := strings.TrimSpace(os.Getenv(EnvRemovePrefix+name)) == "true"
-		if removeOld { // display warning if purge mode is enabled
-			log.Warnf("Purge-Mode was enabled for %s:", name)
-			log.Warn("Old images will be deleted after downloading new images.")
+	if v := strings.TrimSpace(os.Getenv(EnvLabelKey)); v != "" {
+		if strings.ContainsAny(v, "=, ") {
+			log.Fatalf("Invalid %s: %s", EnvLabelKey, v)
+			return
 		}
+		LabelKey = v
+	}
+	log.Infof("Updating containers labeled with %s", LabelKey)
 
-		attrs[name] = &attributes{
-			secret:    sec,
-			auth:      auth,
-			removeOld: removeOld,
+	// Load secrets from env
+	loadWebhooks()
+	// and from the configuration file
+	if v := strings.TrimSpace(os.Getenv(EnvConfig)); v != "" {
+		if err = loadConfigFile(v); err != nil {
+			log.WithError(err).Fatalf("Cannot load %s", v)
+			return
 		}
 	}
 	if len(attrs) == 0 {
 		log.Error("No secrets found.")
-		log.Fatalf("Specify them by setting the environment variable to %s<key>=<secret>", EnvSecretPrefix)
+		log.Fatalf("Specify them by setting the environment variable to %s<key>=<secret> or in the file %s",
+			EnvSecretPrefix, EnvConfig)
+		return
+	}
+	if *exportPath != "" {
+		if err = writeConfig(*exportPath, *exportSecrets); err != nil {
+			log.WithError(err).Fatal("Cannot export configuration")
+			return
+		}
+		log.Infof("Exported %d webhooks to %s", len(attrs), *exportPath)
 		return
 	}
 
+	if adminToken = strings.TrimSpace(os.Getenv(EnvAdminToken)); adminToken != "" {
+		log.Info("Admin endpoints enabled")
+	}
+
 	// Docker connection
 	log.Info("Connecting to Docker Socket")
-	var err error
 	if dc, err = client.NewClientWithOpts(client.FromEnv); err != nil {
 		log.WithError(err).Error("Cannot connect to Docker")
 		return
 	}
-	log.Debug("Negotiating API version for Docker client")
-	dc.NegotiateAPIVersion(context.Background())
+	if v := strings.TrimSpace(os.Getenv(EnvStartupDelay)); v != "" {
+		var delay time.Duration
+		if delay, err = time.ParseDuration(v); err != nil {
+			log.WithError(err).Fatalf("Invalid %s", EnvStartupDelay)
+			return
+		}
+		log.Infof("Waiting %s before starting", delay)
+		time.Sleep(delay)
+	}
 	// Test if we can access the docker daemon
-	if _, err = dc.Info(context.Background()); err != nil {
+	var dockerWait time.Duration
+	if v := strings.TrimSpace(os.Getenv(EnvDockerWait)); v != "" {
+		if dockerWait, err = time.ParseDuration(v); err != nil {
+			log.WithError(err).Fatalf("Invalid %s", EnvDockerWait)
+			return
+		}
+		log.Infof("Waiting up to %s for Docker", dockerWait)
+	}
+	if err = waitForDocker(dockerWait); err != nil {
 		log.WithError(err).Fatal("Connection to docker socket failed")
 		return
 	}
 
+	// Instance lock
+	if mode := strings.ToLower(strings.TrimSpace(os.Getenv(EnvLock))); mode != "" {
+		if v := strings.TrimSpace(os.Getenv(EnvLockName)); v != "" {
+			lockName = v
+		}
+		if err = acquireLock(context.Background()); err != nil {
+			if mode != LockWarn {
+				log.WithError(err).Fatal("Cannot acquire instance lock")
+				return
+			}
+			log.WithError(err).Warn("Cannot acquire instance lock")
+		} else {
+			log.Infof("Acquired instance lock %s", lockName)
+		}
+		defer releaseLock()
+	}
+
+	// Events
+	if v := strings.TrimSpace(os.Getenv(EnvEventURL)); v != "" {
+		if err = startEventPublisher(v, strings.TrimSpace(os.Getenv(EnvEventSubject))); err != nil {
+			log.WithError(err).Fatalf("Invalid %s", EnvEventURL)
+			return
+		}
+		log.Infof("Publishing events to %s (%s)", eventURL.Redacted(), eventSubject)
+	}
+
+	if v := strings.TrimSpace(os.Getenv(EnvRegistryRPS)); v != "" {
+		if registryRPS, err = strconv.ParseFloat(v, 64); err != nil || registryRPS <= 0 {
+			log.Fatalf("Invalid %s: %s", EnvRegistryRPS, v)
+			return
+		}
+		log.Infof("Manifest checks are limited to %v requests per second and registry", registryRPS)
+	}
+
+	// Garbage collection
+	if v := strings.TrimSpace(os.Getenv(EnvGCInterval)); v != "" {
+		var interval time.Duration
+		if interval, err = time.ParseDuration(v); err != nil || interval <= 0 {
+			log.Fatalf("Invalid %s: %s", EnvGCInterval, v)
+			return
+		}
+		gcContainers = strings.TrimSpace(os.Getenv(EnvGCContainers)) == "true"
+		log.Infof("Pruning dangling images every %s", interval)
+		startGC(interval)
+	}
+
+	// Polling
+	for name, a := range attrs {
+		if a.pollInterval > 0 {
+			log.Infof("Polling for new images of %s every %s", name, a.pollInterval)
+			startPolling(name, a)
+		}
+	}
+
 	// Web-Server
-	app := fiber.New(fiber.Config{IdleTimeout: 5 * time.Second})
+	if dockerConfigPath = strings.TrimSpace(os.Getenv(EnvDockerConfig)); dockerConfigPath != "" {
+		if dockerConfigPath, err = configFile(dockerConfigPath); err != nil {
+			log.WithError(err).Fatalf("Invalid %s", EnvDockerConfig)
+			return
+		}
+		log.Infof("Reading registry credentials from %s", dockerConfigPath)
+	} else if dir := strings.TrimSpace(os.Getenv(EnvDockerConfigDir)); dir != "" {
+		// directory of the docker CLI, it may not contain a config.json
+		if dockerConfigPath, err = configFile(dir); err != nil {
+			log.WithError(err).Warnf("Ignoring %s", EnvDockerConfigDir)
+			dockerConfigPath = ""
+		} else {
+			log.Infof("Reading registry credentials from %s", dockerConfigPath)
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvApprovalTimeout)); v != "" {
+		if approvalTimeout, err = time.ParseDuration(v); err != nil || approvalTimeout <= 0 {
+			log.Fatalf("Invalid %s: %s", EnvApprovalTimeout, v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvDrainTimeout)); v != "" {
+		if drainTimeout, err = time.ParseDuration(v); err != nil || drainTimeout < 0 {
+			log.Fatalf("Invalid %s: %s", EnvDrainTimeout, v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvRollbackRetention)); v != "" {
+		if rollbackRetention, err = time.ParseDuration(v); err != nil {
+			log.WithError(err).Fatalf("Invalid %s", EnvRollbackRetention)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvHistorySize)); v != "" {
+		if historySize, err = strconv.Atoi(v); err != nil || historySize <= 0 {
+			log.Fatalf("Invalid %s: %s (expected a positive number)", EnvHistorySize, v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvJobRetention)); v != "" {
+		if jobRetention, err = time.ParseDuration(v); err != nil || jobRetention <= 0 {
+			log.Fatalf("Invalid %s: %s", EnvJobRetention, v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvMaxJobs)); v != "" {
+		if maxJobs, err = strconv.Atoi(v); err != nil || maxJobs <= 0 {
+			log.Fatalf("Invalid %s: %s (expected a positive number)", EnvMaxJobs, v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvHistoryRetention)); v != "" {
+		if historyRetention, err = time.ParseDuration(v); err != nil || historyRetention < 0 {
+			log.Fatalf("Invalid %s: %s", EnvHistoryRetention, v)
+			return
+		}
+	}
+	if historyFile = strings.TrimSpace(os.Getenv(EnvHistoryFile)); historyFile != "" {
+		if err = loadHistory(historyFile); err != nil {
+			log.WithError(err).Fatalf("Cannot read history from %s", historyFile)
+			return
+		}
+		log.Infof("Persisting the update history to %s", historyFile)
+	}
+
+	// purge expired records in the background, once the retentions are known
+	registerSweep("rollback", sweepPrevious)
+	registerSweep("approvals", sweepApprovals)
+	registerSweep("jobs", sweepJobs)
+	registerSweep("history", sweepHistory)
+	startSweeper()
+	if v := strings.TrimSpace(os.Getenv(EnvMaxBodySize)); v != "" {
+		if maxBodySize, err = units.RAMInBytes(v); err != nil || maxBodySize <= 0 {
+			log.Fatalf("Invalid %s: %s", EnvMaxBodySize, v)
+			return
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvMaxLoadSize)); v != "" {
+		if maxLoadSize, err = units.RAMInBytes(v); err != nil || maxLoadSize <= 0 {
+			log.Fatalf("Invalid %s: %s", EnvMaxLoadSize, v)
+			return
+		}
+	}
+	// pull images in the background
+	if strings.TrimSpace(os.Getenv(EnvPrewarm)) == "true" {
+		go prewarm(shutdownCtx)
+	}
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		log.WithError(err).Fatal("Invalid TLS configuration")
+		return
+	}
+	listenAddr := ":80"
+	if tlsConfig != nil {
+		listenAddr = ":443"
+	}
+	if v := strings.TrimSpace(os.Getenv(EnvPort)); v != "" {
+		// bare port or full bind address
+		if _, err = strconv.Atoi(v); err == nil {
+			v = ":" + v
+		}
+		listenAddr = v
+	}
+	// Unix socket instead of TCP
+	unixSocket := strings.TrimSpace(os.Getenv(EnvUnixSocket))
+	socketMode := os.FileMode(0660)
+	if v := strings.TrimSpace(os.Getenv(EnvUnixSocketMode)); v != "" {
+		m, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || m > 0777 {
+			log.Fatalf("Invalid %s: %s (expected an octal file mode)", EnvUnixSocketMode, v)
+			return
+		}
+		socketMode = os.FileMode(m)
+	}
+	if unixSocket != "" {
+		listenAddr = "unix:" + unixSocket
+	}
+	if allowedNets, err = parseCIDRs(os.Getenv(EnvAllowCIDR)); err != nil {
+		log.WithError(err).Fatalf("Invalid %s", EnvAllowCIDR)
+		return
+	}
+	if len(allowedNets) > 0 && unixSocket != "" {
+		log.Fatalf("%s can't be used with %s, clients of sockets have no IP", EnvAllowCIDR, EnvUnixSocket)
+		return
+	}
+	var trustedProxies []string
+	for _, p := range strings.Split(os.Getenv(EnvTrustedProxies), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			trustedProxies = append(trustedProxies, p)
+		}
+	}
+	if _, err = parseCIDRs(strings.Join(trustedProxies, ",")); err != nil {
+		log.WithError(err).Fatalf("Invalid %s", EnvTrustedProxies)
+		return
+	}
+	// timeouts of connections, long updates are answered after the write timeout started
+	readTimeout, writeTimeout, idleTimeout := time.Minute, time.Minute, 5*time.Second
+	for env, d := range map[string]*time.Duration{
+		EnvReadTimeout:  &readTimeout,
+		EnvWriteTimeout: &writeTimeout,
+		EnvIdleTimeout:  &idleTimeout,
+	} {
+		if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+			if *d, err = time.ParseDuration(v); err != nil || *d < 0 {
+				log.Fatalf("Invalid %s: %s", env, v)
+				return
+			}
+		}
+	}
+	log.Infof("Listening on %s", listenAddr)
+	cfg := fiber.Config{
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+		// image tarballs are streamed, other bodies are limited by limitBody
+		StreamRequestBody: true,
+	}
+	trustProxies(&cfg, trustedProxies)
+	app := fiber.New(cfg)
+	app.Use(recoverPanic)
+	// bodies are limited by each route, image tarballs may be larger
+	limit := limitBody(maxBodySize)
+	// admin endpoints, on a separate listener if configured
+	adminApp := app
+	adminAddr := strings.TrimSpace(os.Getenv(EnvAdminAddr))
+	if adminAddr != "" {
+		adminApp = fiber.New(fiber.Config{
+			ReadTimeout:           readTimeout,
+			WriteTimeout:          writeTimeout,
+			IdleTimeout:           idleTimeout,
+			DisableStartupMessage: true,
+		})
+		adminApp.Use(recoverPanic)
+	}
+	registerAdminRoutes(adminApp)
+	// health of yadwh itself, before /:name
+	adminApp.Get("/healthz", handleHealthz)
+	// prometheus metrics, before /:name
+	adminApp.Get("/metrics", handleMetrics())
+	// reject clients outside of WH_ALLOW_CIDR before checking secrets
+	app.Use(allowlist)
+	// name and secret specified by header
+	app.Post("/", limit, func(ctx *fiber.Ctx) error {
+		name := ctx.Get("X-YADWH-Name")
+		if name == "" {
+			return fiber.NewError(400, "name not found")
+		}
+		secret := ctx.Get("X-YADWH-Secret")
+		if secret == "" && clientCertificate(ctx) == nil {
+			return fiber.NewError(401, "secret not found")
+		}
+		return process(name, secret, ctx)
+	})
 	// secret specified by query, header or body
-	app.All("/:name", func(ctx *fiber.Ctx) error {
+	app.All("/:name", limit, func(ctx *fiber.Ctx) error {
 		name := ctx.Params("name")
-		var secret string
-		// secret by query
-		if secret = ctx.Query("secret"); secret != "" {
-			return process(name, secret, ctx)
+		// signed requests (e.g. GitHub deliveries)
+		if a, ok := attrs[strings.TrimSpace(name)]; ok && a.signature != "" {
+			return process(name, "", ctx)
 		}
-		if secret = ctx.Get("X-YADWH-Secret"); secret != "" {
-			return process(name, secret, ctx)
+		var secret string
+		for _, source := range secretSources {
+			switch source {
+			case SecretSourceQuery:
+				secret = ctx.Query("secret")
+			case SecretSourceHeader:
+				secret = ctx.Get("X-YADWH-Secret")
+			case SecretSourceBody:
+				// JSON bodies are update requests
+				if !isJSON(ctx) {
+					secret = string(ctx.Body())
+				}
+			}
+			if secret != "" {
+				return process(name, secret, ctx)
+			}
 		}
-		if secret = string(ctx.Body()); secret != "" {
-			return process(name, secret, ctx)
+		// authorize by client certificate
+		if clientCertificate(ctx) != nil {
+			return process(name, "", ctx)
 		}
 		return fiber.NewError(401, "secret not found")
 	})
+	// images of the matched containers
+	app.Get("/:name/:secret/images", limit, func(ctx *fiber.Ctx) error {
+		return images(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+	// containers matched by the webhook
+	app.Get("/:name/:secret/match", limit, func(ctx *fiber.Ctx) error {
+		return matched(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+	// state of a background job
+	app.Get("/:name/:secret/jobs/:id", limit, func(ctx *fiber.Ctx) error {
+		return handleJob(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+	// roll back to previous images
+	app.All("/:name/:secret/rollback", limit, func(ctx *fiber.Ctx) error {
+		return rollback(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+	// deploy from image tarball
+	app.Post("/:name/:secret/load", func(ctx *fiber.Ctx) error {
+		return load(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
 	// secret specified in URL
-	app.All("/:name/:secret", func(ctx *fiber.Ctx) error {
+	app.All("/:name/:secret", limit, func(ctx *fiber.Ctx) error {
 		return process(ctx.Params("name"), ctx.Params("secret"), ctx)
 	})
 
-	sc := make(chan os.Signal)
-	go func(s chan os.Signal) {
-		if err := app.Listen(":80"); err != nil {
-			log.WithError(err).Warn("Cannot listen on port 80")
+	if strings.TrimSpace(os.Getenv(EnvEmitReadyEvent)) == "true" {
+		app.Hooks().OnListen(func() error {
+			return emitReadyEvent(listenAddr)
+		})
+	}
+
+	// buffered, so a signal arriving before the receive isn't dropped
+	sc := make(chan os.Signal, 1)
+	// proceed to shut down, without blocking if a shutdown is already pending
+	stop := func() {
+		select {
+		case sc <- syscall.SIGQUIT:
+		default:
+		}
+	}
+	go func() {
+		if unixSocket != "" {
+			ln, err := listenUnix(unixSocket, socketMode)
+			if err != nil {
+				log.WithError(err).Warnf("Cannot listen on %s", listenAddr)
+			} else {
+				if tlsConfig != nil {
+					ln = tls.NewListener(ln, tlsConfig)
+				}
+				if err = app.Listener(ln); err != nil {
+					log.WithError(err).Warnf("Cannot serve on %s", listenAddr)
+				}
+			}
+		} else if tlsConfig != nil {
+			if ln, err := tls.Listen("tcp", listenAddr, tlsConfig); err != nil {
+				log.WithError(err).Warnf("Cannot listen on %s", listenAddr)
+			} else if err = app.Listener(ln); err != nil {
+				log.WithError(err).Warnf("Cannot serve on %s", listenAddr)
+			}
+		} else if err := app.Listen(listenAddr); err != nil {
+			log.WithError(err).Warnf("Cannot listen on %s", listenAddr)
 		}
-		sc <- syscall.SIGQUIT // proceed to shut down
-	}(sc)
+		stop()
+	}()
+	if adminApp != app {
+		go func() {
+			log.Infof("Admin endpoints listening on %s", adminAddr)
+			if err := adminApp.Listen(adminAddr); err != nil {
+				log.WithError(err).Warnf("Cannot listen on %s", adminAddr)
+			}
+			stop()
+		}()
+	}
 
-	signal.Notify(sc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
-	_ = <-sc
+	signal.Notify(sc, syscall.SIGTERM, syscall.SIGINT)
+	<-sc
 
 	log.Info("Shutting down Web-Server")
+	// let running updates finish before cancelling them
+	drain(drainTimeout)
+	shutdown()
 	if err = app.Shutdown(); err != nil {
 		log.WithError(err).Error("cannot shutdown webserver")
 	}
+	// closing the listener usually removes the socket already
+	if unixSocket != "" {
+		if err = os.Remove(unixSocket); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("Cannot remove socket %s", unixSocket)
+		}
+	}
+	if adminApp != app {
+		if err = adminApp.Shutdown(); err != nil {
+			log.WithError(err).Error("cannot shutdown admin webserver")
+		}
+	}
+}
+
+// watchedBy returns the names of all webhooks in the label of a container
+func watchedBy(cont *types.Container) (watched []string) {
+	for _, w := range strings.Split(cont.Labels[LabelKey], ",") {
+		watched = append(watched, strings.TrimSpace(w))
+	}
+	return
+}
+
+// authFor returns the registry auth of the first webhook with auth
+func authFor(webhooks []string) string {
+	for _, w := range webhooks {
+		if a, ok := attrs[w]; ok && a.auth != "" {
+			return a.auth
+		}
+	}
+	return ""
 }
 
 func isMonitored(watched []string, name string) (monitor bool) {
@@ -158,151 +746,967 @@ func isMonitored(watched []string, name string) (monitor bool) {
 	return false
 }
 
+// allowsMethod checks if the webhook may be triggered with the HTTP method
+func (a *attributes) allowsMethod(method string) bool {
+	if len(a.methods) == 0 {
+		return true
+	}
+	for _, m := range a.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// names checks if the container is one of the containers named by the webhook
+func (a *attributes) names(cont *types.Container) bool {
+	for _, n := range cont.Names {
+		// docker prefixes names with a slash
+		n = strings.TrimPrefix(n, "/")
+		for _, c := range a.containers {
+			if n == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trimID strips the digest algorithm (e.g. sha256:) from id
+// and truncates it to idLength characters
 func trimID(id string) string {
-	if len(id) > 16 {
-		return id[:15] + "-"
+	if i := strings.IndexRune(id, ':'); i >= 0 {
+		id = id[i+1:]
+	}
+	if len(id) > idLength {
+		return id[:idLength]
 	}
 	return id
 }
 
-func (a *attributes) pullImage(c *types.Container) (body []byte, err error) {
+func (a *attributes) pullImage(dctx context.Context, c *types.Container) (body []byte, err error) {
 	log.Infof("Pulling image for container %s@%s", trimID(c.ID), c.Image)
 	var reader io.ReadCloser
-	defer func() {
-		if err = reader.Close(); err != nil {
-			log.WithError(err).Warn("Cannot close reader")
-		}
-	}()
-	if reader, err = dc.ImagePull(context.Background(), c.Image, types.ImagePullOptions{
-		RegistryAuth: a.auth,
+	if reader, err = dc.ImagePull(dctx, c.Image, types.ImagePullOptions{
+		RegistryAuth: a.imageAuth(dctx, c.Image),
+		Platform:     a.platform,
 	}); err != nil {
+		if isAuthError(err) {
+			log.WithError(err).Warnf("Registry denied access to %s, the registry credentials may be wrong or expired", c.Image)
+			return nil, fmt.Errorf("%w: %v", errAuthFailed, err)
+		}
 		log.WithError(err).Warn("Cannot pull image")
+		return nil, err
 	}
+	defer func() {
+		if reader == nil {
+			return
+		}
+		if cerr := reader.Close(); cerr != nil {
+			log.WithError(cerr).Warn("Cannot close reader")
+		}
+	}()
 	body, err = io.ReadAll(reader)
 	return
 }
 
-func deleteImage(imageID string) (err error) {
-	_, err = dc.ImageRemove(context.Background(), imageID, types.ImageRemoveOptions{})
+func deleteImage(dctx context.Context, imageID string) (err error) {
+	_, err = dc.ImageRemove(dctx, imageID, types.ImageRemoveOptions{})
 	return
 }
 
-func process(name, secret string, ctx *fiber.Ctx) (err error) {
-	name = strings.TrimSpace(name)
-	secret = strings.TrimSpace(secret)
+// imageInUse checks if any container (running or not) was created from the image imageID
+func imageInUse(dctx context.Context, imageID string) (bool, error) {
+	list, err := dc.ContainerList(dctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return false, err
+	}
+	for _, c := range list {
+		if c.ImageID == imageID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	// Check if secret is valid
+// authorize returns the attributes of the webhook name if secret is valid
+func authorize(name, secret string) (*attributes, error) {
 	expected, ok := attrs[name]
 	if !ok || expected == nil {
-		return ErrWebhookNotFound
+		return nil, ErrWebhookNotFound
 	}
-	if secret != expected.secret {
-		return ErrSecretInvalid
+	// plain secrets are disabled to prevent downgrades
+	if expected.signature != "" || !secretEqual(secret, expected.secret) {
+		return nil, ErrSecretInvalid
 	}
+	return expected, nil
+}
 
-	// Find containers with label
+// secretEqual compares two secrets in constant time.
+// Both are hashed first, so the time doesn't depend on the length of the expected secret either
+func secretEqual(given, expected string) bool {
+	g, e := sha256.Sum256([]byte(given)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(g[:], e[:]) == 1
+}
+
+// matchingContainers returns all containers monitored by the webhook name
+func matchingContainers(dctx context.Context, name string) (matched []types.Container, err error) {
+	args := filters.NewArgs(filters.Arg("label", LabelKey))
+	if a, ok := attrs[name]; ok {
+		for _, sel := range a.selector {
+			args.Add("label", sel)
+		}
+	}
 	var containerList []types.Container
-	if containerList, err = dc.ContainerList(context.Background(), types.ContainerListOptions{
-		Filters: filters.NewArgs(filters.Arg("label", LabelKey)),
+	if containerList, err = dc.ContainerList(dctx, types.ContainerListOptions{
+		Filters: args,
 	}); err != nil {
-		return fiber.NewError(500, err.Error())
+		return
+	}
+	// containers named by the webhook, labeled or not
+	if a, ok := attrs[name]; ok && len(a.containers) > 0 {
+		var all []types.Container
+		if all, err = dc.ContainerList(dctx, types.ContainerListOptions{}); err != nil {
+			return
+		}
+		listed := make(map[string]bool, len(containerList))
+		for _, cont := range containerList {
+			listed[cont.ID] = true
+		}
+		for _, cont := range all {
+			if !listed[cont.ID] && a.names(&cont) {
+				containerList = append(containerList, cont)
+			}
+		}
+	}
+	for _, cont := range containerList {
+		// check if the container is monitored by this webhook
+		if !isMonitored(watchedBy(&cont), name) {
+			if a, ok := attrs[name]; !ok || !a.names(&cont) {
+				continue
+			}
+		}
+		if a, ok := attrs[name]; ok {
+			ok, err := a.matches(dctx, &cont)
+			if err != nil {
+				log.WithError(err).Warnf("Cannot evaluate match expression for container %s", trimID(cont.ID))
+				continue
+			}
+			if !ok {
+				log.Debugf("Container %s doesn't match expression of %s", trimID(cont.ID), name)
+				continue
+			}
+		}
+		matched = append(matched, cont)
 	}
+	return
+}
 
-	log.Infof("Finding and restarting containers with label: %s", name)
+func process(name, secret string, ctx *fiber.Ctx) (err error) {
+	// the name points into the request, which is reused after the handler returned,
+	// but is kept as metric label, map key and by background jobs
+	name = utils.CopyString(strings.TrimSpace(name))
+	secret = strings.TrimSpace(secret)
 
-	// list that contains all restarted containers
-	var restarted []types.Container
+	// correlates the log lines and the response of this request
+	requestID := randomID(4)
+	ctx.Set(HeaderRequestID, requestID)
+	logger := log.WithField("req", requestID).WithField("webhook", name)
 
-	for _, cont := range containerList {
-		// Check if label contains webhook
-		watched := []string{
-			cont.Labels[LabelKey],
+	// Check if signature, secret or client certificate is valid
+	var expected *attributes
+	if expected, err = authorizeRequest(name, secret, ctx); err != nil {
+		logger.WithError(err).Warn("Unauthorized request")
+		return
+	}
+	// approvals are always posted
+	approved, _ := ctx.Locals(localApproved).(map[string]bool)
+	if approved == nil && !expected.allowsMethod(ctx.Method()) {
+		logger.Warnf("Method %s is not allowed", ctx.Method())
+		ctx.Set(fiber.HeaderAllow, strings.Join(expected.methods, ", "))
+		return fiber.ErrMethodNotAllowed
+	}
+	logger.Infof("Webhook triggered by %s", ctx.IP())
+	metricRequests.WithLabelValues(name).Inc()
+	defer prometheus.NewTimer(metricDuration.WithLabelValues(name)).ObserveDuration()
+	if isPaused() {
+		return ErrPaused
+	}
+	if isDraining() {
+		return ErrShuttingDown
+	}
+
+	// report drift without deploying
+	if expected.audit {
+		return audit(name, requestID, expected, ctx)
+	}
+
+	// parse optional request body, the body of an approval is not meant for the update
+	req := new(updateRequest)
+	if approved == nil {
+		if req, err = parseUpdateRequest(ctx); err != nil {
+			return
 		}
-		if strings.Contains(watched[0], ",") {
-			watched = strings.Split(watched[0], ",")
+	}
+	var resources *appliedResources
+	if resources, err = expected.resources(req); err != nil {
+		return
+	}
+
+	u := &updateRun{
+		name:      name,
+		expected:  expected,
+		req:       req,
+		resources: resources,
+		requestID: requestID,
+		logger:    logger,
+	}
+	// images loaded from a tarball don't need to be pulled
+	u.loaded, _ = ctx.Locals(localLoaded).(map[string]bool)
+	// neither do images of a rollback
+	u.isRollback, _ = ctx.Locals(localRollback).(bool)
+	// containers approved by an admin, nil if this update was not approved
+	u.approved = approved
+	u.dryRun = expected.dryRun || ctx.Query("dryRun") == "true"
+
+	// the update counts as running while it waits for a running update, see drain.
+	// Jobs and streams untrack the update once it finished
+	untrack, ok := track(name)
+	if !ok {
+		return ErrShuttingDown
+	}
+	u.untrack = untrack
+
+	// only one update per webhook at a time
+	if expected.conflictMode == ConflictReject {
+		if !expected.lock.tryLock() {
+			untrack()
+			since := expected.lock.runningSince()
+			return ctx.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":         "update already running",
+				"running_since": since,
+			})
 		}
+	} else if expected.async {
+		// the job waits for the running update in the background
+		return u.enqueue(ctx, false)
+	} else {
+		lctx, cancel := context.Context(ctx.Context()), context.CancelFunc(func() {})
+		if expected.queueTimeout > 0 {
+			lctx, cancel = context.WithTimeout(lctx, expected.queueTimeout)
+		}
+		err = expected.lock.lock(lctx)
+		cancel()
+		if err != nil {
+			untrack()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(expected.queueTimeout.Seconds())+1))
+			return ctx.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":         "update still running after " + expected.queueTimeout.String(),
+				"running_since": expected.lock.runningSince(),
+			})
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "cancelled while waiting for running update")
+		}
+	}
 
-		// check if the container is monitored by this webhook
-		if !isMonitored(watched, name) {
-			continue
+	if expected.async {
+		return u.enqueue(ctx, true)
+	}
+	if ctx.Query("stream") == "sse" {
+		// the stream runs the update in the background, which releases the lock once it finished
+		return u.stream(ctx)
+	}
+	defer untrack()
+	defer expected.lock.unlock()
+
+	var result *UpdateResult
+	if result, err = u.run(); err != nil {
+		return
+	}
+	return result.render(ctx)
+}
+
+// pullResult is the output or error of pulling an image
+type pullResult struct {
+	body []byte
+	err  error
+}
+
+// updateRun contains the settings of a single update of a webhook
+type updateRun struct {
+	name       string
+	expected   *attributes
+	req        *updateRequest
+	resources  *appliedResources
+	loaded     map[string]bool
+	isRollback bool
+	approved   map[string]bool
+	dryRun     bool
+
+	// untrack marks the update as finished, see track
+	untrack func()
+
+	// requestID is returned by the response and logged by logger with the webhook
+	requestID string
+	logger    *log.Entry
+
+	// progress is called whenever the update enters a new phase, if set
+	progress func(ev progressEvent)
+}
+
+// run updates the containers of the webhook, the update lock of the webhook has to be held
+func (u *updateRun) run() (result *UpdateResult, err error) {
+	name, expected, req, resources := u.name, u.expected, u.req, u.resources
+	logger := u.logger
+	loaded, isRollback, approved := u.loaded, u.isRollback, u.approved
+
+	// the update outlives the request (background jobs, streams), but is cancelled on shutdown.
+	// Limit the duration of the whole update
+	dctx, cancel := context.Context(shutdownCtx), context.CancelFunc(func() {})
+	if expected.maxDuration > 0 {
+		dctx, cancel = context.WithTimeout(dctx, expected.maxDuration)
+	}
+	defer cancel()
+
+	// post the result or error, also if the update failed
+	defer func() {
+		rec := newRunRecord(u.requestID, result, err)
+		recordRun(name, rec)
+		recordHistory(name, rec, result)
+		expected.notifyUpdate(name, result, err)
+	}()
+
+	// guards the state shared by containers updated in parallel, see attributes.concurrency
+	var mu sync.Mutex
+
+	// phase of the update, used to report progress and where a timeout occurred
+	var phase string
+	setPhase := func(p string) {
+		mu.Lock()
+		defer mu.Unlock()
+		phase = p
+		u.report(p)
+	}
+	setPhase("list")
+
+	// Find containers with label
+	var containerList []types.Container
+	if containerList, err = matchingContainers(dctx, name); err != nil {
+		return nil, fiber.NewError(500, err.Error())
+	}
+
+	logger.Infof("Finding and restarting containers with label: %s", name)
+
+	// containers which require an approval
+	var needApproval []string
+
+	result = &UpdateResult{RequestID: u.requestID, Restarted: []restartedContainer{}, Containers: []containerOutcome{}}
+
+	// each container is locked while it's updated, see locks.go
+	holder := "webhook " + name
+
+	// images pulled by this update, each image is pulled once
+	pulled := make(map[string]pullResult)
+	lookupPull := func(ref string) (pullResult, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		p, ok := pulled[ref]
+		return p, ok
+	}
+
+	// config of the re-created containers before the update, restored if an atomic update fails
+	snapshots := make(map[string]*containerSnapshot)
+
+	// hash of the watched config
+	var watchHash string
+	if expected.watchPath != "" {
+		if watchHash, err = hashPath(expected.watchPath); err != nil {
+			return nil, fiber.NewError(500, "cannot hash watched path: "+err.Error())
+		}
+	}
+
+	// an approved update only contains the approved containers
+	if approved != nil {
+		var list []types.Container
+		for _, cont := range containerList {
+			if approved[cont.ID] {
+				list = append(list, cont)
+			}
+		}
+		containerList = list
+	}
+
+	// containers with a lower order label are updated first
+	sortByOrder(containerList)
+
+	// updates a single container, the outcome is added to part.
+	// Returns true if the remaining containers shouldn't be updated anymore
+	update := func(cont types.Container, part *UpdateResult) (abort bool) {
+		clog := logger.WithField("container", trimID(cont.ID))
+
+		if approved == nil && cont.Labels[LabelApproval] == "true" && !u.dryRun && expected.mode != ModePullOnly {
+			mu.Lock()
+			needApproval = append(needApproval, cont.ID)
+			mu.Unlock()
+			part.outcome(cont, ActionApproval, "", nil)
+			return false
+		}
+
+		setPhase("lock " + trimID(cont.ID))
+		unlockContainer, err := lockContainer(dctx, containerKey(cont.Names, cont.ID), holder)
+		if err != nil {
+			part.fail(cont, FailLock, err)
+			return false
+		}
+		defer unlockContainer()
+
+		// deploy the requested tag instead of the current one
+		if req.Tag != "" && !isRollback && loaded == nil {
+			ref, err := withTag(cont.Image, req.Tag)
+			if err != nil {
+				clog.WithError(err).Warnf("Cannot deploy tag %s for image %s", req.Tag, cont.Image)
+				part.fail(cont, FailPrepare, err)
+				return false
+			}
+			clog.Infof("Deploying %s instead of %s", ref, cont.Image)
+			cont.Image = ref
+		}
+
+		var (
+			body       []byte
+			rollbackTo previousImage
+		)
+		if isRollback {
+			var ok bool
+			if rollbackTo, ok = previousFor(containerKey(cont.Names, cont.ID)); !ok {
+				clog.Infof("Skipping container %s, no previous image recorded", trimID(cont.ID))
+				part.skip(cont, SkipNoPrevious)
+				return false
+			}
+		} else if loaded != nil {
+			if !loaded[normalizeRef(cont.Image)] {
+				clog.Infof("Skipping container %s, image %s was not loaded", trimID(cont.ID), cont.Image)
+				part.skip(cont, SkipNotLoaded)
+				return false
+			}
+		} else if expected.mode == ModeRestartOnly {
+			clog.Infof("Re-creating container %s from the local image %s", trimID(cont.ID), cont.Image)
+		} else if p, ok := lookupPull(cont.Image); ok {
+			// replicas share the pull of their image
+			clog.Infof("Image %s of container %s was already pulled", cont.Image, trimID(cont.ID))
+			body, err = p.body, p.err
+			if errors.Is(err, errAuthFailed) {
+				part.reject(cont, RejectAuthFailed, err)
+				return false
+			}
+			if err != nil {
+				part.fail(cont, FailPull, err)
+				return false
+			}
+		} else {
+			setPhase("pull " + trimID(cont.ID))
+			var unlock func()
+			if unlock, err = lockImage(dctx, cont.Image, holder); err != nil {
+				part.fail(cont, FailLock, err)
+				return false
+			}
+			// a replica updated in parallel may have pulled the image while waiting for the lock
+			if p, ok := lookupPull(cont.Image); ok {
+				body, err = p.body, p.err
+			} else {
+				body, err = expected.pullImage(dctx, &cont)
+				mu.Lock()
+				pulled[cont.Image] = pullResult{body: body, err: err}
+				mu.Unlock()
+				if err != nil {
+					metricPullErrors.WithLabelValues(name).Inc()
+				}
+			}
+			unlock()
+			if errors.Is(err, errAuthFailed) {
+				part.reject(cont, RejectAuthFailed, err)
+				if expected.authFailFast {
+					clog.Warnf("Aborting update of %s after authentication failure", name)
+					return true
+				}
+				return false
+			}
+			if err != nil {
+				part.fail(cont, FailPull, err)
+				return false
+			}
+			fmt.Println()
+			fmt.Println(string(body))
+			fmt.Println()
+		}
+
+		// leave the container running, only report the pulled image
+		if expected.mode == ModePullOnly && !isRollback && loaded == nil {
+			changed, err := imageChanged(dctx, &cont)
+			if err != nil {
+				clog.WithError(err).Warnf("Cannot check image of container %s", trimID(cont.ID))
+				part.fail(cont, FailInspect, err)
+				return false
+			}
+			clog.Infof("Pulled image %s of container %s (changed: %v)", cont.Image, trimID(cont.ID), changed)
+			part.pull(cont, changed)
+			return false
+		}
+
+		// skip containers whose image (and watched config) didn't change
+		if !isRollback && !expected.force && !req.Force && resources == nil && expected.mode != ModeRestartOnly &&
+			(watchHash == "" || cont.Labels[LabelConfigHash] == watchHash) {
+			changed, err := imageChanged(dctx, &cont)
+			if err != nil {
+				clog.WithError(err).Warnf("Cannot check image of container %s", trimID(cont.ID))
+			} else if !changed {
+				clog.Infof("Container %s is already up to date (%s), skipping", trimID(cont.ID), trimID(cont.ImageID))
+				if u.dryRun {
+					part.plan(cont, PlanUpToDate)
+				} else {
+					part.skip(cont, SkipUnchanged)
+				}
+				return false
+			}
 		}
 
-		var body []byte
-		if body, err = expected.pullImage(&cont); err != nil {
-			continue
+		// skip containers updated by a previous (partially failed) run
+		if expected.resume && !req.Force && !isRollback &&
+			(watchHash == "" || cont.Labels[LabelConfigHash] == watchHash) {
+			deployed, err := alreadyDeployed(dctx, &cont, name)
+			if err != nil {
+				clog.WithError(err).Warnf("Cannot check deploy stamp of container %s", trimID(cont.ID))
+			} else if deployed {
+				clog.Infof("Container %s already runs the current image, skipping", trimID(cont.ID))
+				part.skip(cont, SkipDeployed)
+				return false
+			}
 		}
-		fmt.Println()
-		fmt.Println(string(body))
-		fmt.Println()
 
+		// don't touch the container in a dry-run
+		if u.dryRun {
+			clog.Infof("Dry-run: container %s would be updated", trimID(cont.ID))
+			part.plan(cont, PlanUpdate)
+			return false
+		}
+
+		// verify signature of pulled image
+		var signature string
+		if expected.cosignKey != "" {
+			setPhase("verify " + trimID(cont.ID))
+			if err = expected.verifySignature(dctx, cont.Image); err != nil {
+				clog.WithError(err).Warnf("Refusing to update container %s", trimID(cont.ID))
+				if errors.Is(err, errSignatureInvalid) {
+					part.reject(cont, RejectSignatureInvalid, err)
+				} else {
+					part.fail(cont, FailVerify, err)
+				}
+				return false
+			}
+			signature = "verified"
+		}
+
+		setPhase("inspect " + trimID(cont.ID))
 		var inspect types.ContainerJSON
-		if inspect, err = dc.ContainerInspect(context.Background(), cont.ID); err != nil {
-			log.WithError(err).Warn("Cannot inspect container")
-			continue
+		if inspect, err = dc.ContainerInspect(dctx, cont.ID); err != nil {
+			clog.WithError(err).Warn("Cannot inspect container")
+			part.fail(cont, FailInspect, err)
+			return false
+		}
+
+		// point the image reference back to the previous image
+		if isRollback {
+			clog.Infof("Rolling back %s to image %s", inspect.Config.Image, trimID(rollbackTo.ImageID))
+			setPhase("rollback " + trimID(cont.ID))
+			if err = dc.ImageTag(dctx, rollbackTo.ImageID, inspect.Config.Image); err != nil {
+				clog.WithError(err).Warn("Cannot tag previous image")
+				part.fail(cont, FailPrepare, err)
+				return false
+			}
+		}
+
+		// config to restore the container if the new one cannot be started
+		snapshot := snapshotContainer(canonicalName(cont.Names), &inspect)
+		// the new container runs the requested tag
+		if req.Tag != "" && !isRollback && loaded == nil {
+			inspect.Config.Image = cont.Image
+		}
+
+		if err = resources.apply(inspect.Config, inspect.HostConfig); err != nil {
+			clog.WithError(err).Warn("Cannot apply resource limits")
+			part.fail(cont, FailPrepare, err)
+			return false
+		}
+
+		// check if the new image changed its entrypoint or cmd
+		var warnings []string
+		if warnings, err = checkDefaults(dctx, inspect.Config, cont.ImageID, expected.adoptDefaults); err != nil {
+			clog.WithError(err).Warn("Cannot compare image defaults")
+		}
+		for _, w := range warnings {
+			clog.Warnf("Container %s: %s", trimID(cont.ID), w)
+		}
+
+		// name and labels of the new container
+		containerName := canonicalName(cont.Names)
+		if aliases := linkAliases(cont.Names); len(aliases) > 0 {
+			clog.Warnf("Legacy link aliases of container %s are lost until the linking containers are re-created: %s",
+				trimID(cont.ID), strings.Join(aliases, ", "))
+		}
+
+		if expected.stamp {
+			stampDeploy(dctx, inspect.Config, name)
+		}
+		if watchHash != "" {
+			if inspect.Config.Labels == nil {
+				inspect.Config.Labels = make(map[string]string)
+			}
+			inspect.Config.Labels[LabelConfigHash] = watchHash
+		}
+		notStarted := req.NoStart || cont.Labels[LabelNoStart] == "true"
+		// set if the container was re-created but didn't become healthy
+		var unhealthy *failedContainer
+
+		// containers in a restart loop would race with the daemon
+		var restarting string
+		if inspect.State != nil && inspect.State.Restarting {
+			restarting = expected.restarting
+			setPhase("restarting " + trimID(cont.ID))
+			var killed bool
+			if killed, err = expected.stopRestarting(dctx, &inspect); err != nil {
+				clog.WithError(err).Warnf("Cannot handle restarting container %s", trimID(cont.ID))
+				if errors.Is(err, errRestartingSkipped) {
+					part.skip(cont, SkipRestarting)
+				} else {
+					part.fail(cont, FailStop, err)
+				}
+				return false
+			}
+			if killed {
+				restarting = RestartingKill
+			}
+		}
+
+		// start the new container next to the old one if possible
+		strategy := StrategyRecreate
+		if expected.zeroDowntime && !notStarted {
+			if reason := zeroDowntimeBlocker(&inspect); reason != "" {
+				clog.Infof("Container %s can't run next to its replacement (%s), re-creating it", trimID(cont.ID), reason)
+			} else {
+				strategy = StrategyZeroDowntime
+			}
+		}
+		var created container.ContainerCreateCreatedBody
+		if strategy == StrategyZeroDowntime {
+			setPhase("replace " + trimID(cont.ID))
+			if created.ID, err = expected.startReplacement(dctx, &inspect, containerName); err != nil {
+				clog.WithError(err).Warnf("Replacement of container %s didn't come up, keeping the old container", trimID(cont.ID))
+				part.fail(cont, FailStart, err)
+				return false
+			}
 		}
 
 		// stop container
-		log.Infof("Stopping container %s/%s(%s)", cont.ID, cont.Image, cont.ImageID)
-		min := time.Minute
-		if err = dc.ContainerStop(context.Background(), cont.ID, &min); err != nil {
-			log.WithError(err).Warn("Cannot restart container")
-			continue
+		clog.Infof("Stopping container %s/%s(%s)", trimID(cont.ID), cont.Image, trimID(cont.ImageID))
+		setPhase("stop " + trimID(cont.ID))
+		timeout := stopTimeout(&inspect, expected.stopTimeout)
+		if err = dc.ContainerStop(dctx, cont.ID, &timeout); err != nil {
+			clog.WithError(err).Warn("Cannot restart container")
+			part.fail(cont, FailStop, err)
+			if strategy == StrategyZeroDowntime {
+				discardContainer(created.ID)
+			}
+			return false
 		}
 
 		// remove container
 		if !inspect.HostConfig.AutoRemove {
-			log.Infof("Removing container %s/%s(%s)", cont.ID, cont.Image, cont.ImageID)
-			if err = dc.ContainerRemove(context.Background(), cont.ID, types.ContainerRemoveOptions{}); err != nil {
-				log.WithError(err).Warn("Cannot remove container")
-				continue
+			clog.Infof("Removing container %s/%s(%s)", trimID(cont.ID), cont.Image, trimID(cont.ImageID))
+			setPhase("remove " + trimID(cont.ID))
+			if err = dc.ContainerRemove(dctx, cont.ID, types.ContainerRemoveOptions{}); err != nil {
+				clog.WithError(err).Warn("Cannot remove container")
+				part.fail(cont, FailRemove, err)
+				if strategy == StrategyZeroDowntime {
+					discardContainer(created.ID)
+				}
+				return false
 			}
 		} else {
-			log.Infof("No need to remove container %s/%s(%s)", cont.ID, cont.Image, cont.ImageID)
+			clog.Infof("No need to remove container %s/%s(%s)", trimID(cont.ID), cont.Image, trimID(cont.ImageID))
 		}
 
-		// create cont
-		containerName := ""
-		if len(cont.Names) > 0 {
-			containerName = cont.Names[0]
+		if strategy == StrategyZeroDowntime {
+			// the replacement takes over the name of the removed container
+			setPhase("rename " + trimID(created.ID))
+			if err = dc.ContainerRename(dctx, created.ID, containerName); err != nil {
+				clog.WithError(err).Warnf("Cannot rename replacement %s", trimID(created.ID))
+				warnings = append(warnings, fmt.Sprintf("running as %s, cannot rename to %s: %v",
+					strings.TrimPrefix(containerName, "/")+replacementSuffix, strings.TrimPrefix(containerName, "/"), err))
+			}
+		} else {
+			// wait for resources of the old container to be released
+			if expected.swapDelay > 0 {
+				clog.Infof("Waiting %s before re-creating container", expected.swapDelay)
+				setPhase("swap delay " + trimID(cont.ID))
+				select {
+				case <-dctx.Done():
+					part.fail(cont, FailCreate, dctx.Err())
+					return false
+				case <-time.After(expected.swapDelay):
+				}
+			}
+
+			// the name may have been taken by another container in the meantime
+			setPhase("create " + trimID(cont.ID))
+			if err = checkName(dctx, containerName, cont.ID); err != nil {
+				clog.WithError(err).Warn("Refusing to re-create container")
+				part.fail(cont, FailNameConflict, err)
+				return false
+			}
+
+			clog.Infof("Re-creating container with image %s", inspect.Config.Image)
+			if created, err = dc.ContainerCreate(dctx,
+				inspect.Config,
+				inspect.HostConfig,
+				networkingConfig(&inspect),
+				expected.platformSpec(),
+				containerName,
+			); err != nil {
+				clog.WithError(err).Warn("Cannot create container")
+				part.fail(cont, FailCreate, err)
+				return false
+			}
 		}
 
-		log.Infof("Re-creating container with image %s", inspect.Config.Image)
-		var created container.ContainerCreateCreatedBody
-		if created, err = dc.ContainerCreate(context.Background(),
-			inspect.Config,
-			inspect.HostConfig,
-			&network.NetworkingConfig{
-				EndpointsConfig: inspect.NetworkSettings.Networks,
-			},
-			nil,
-			containerName,
-		); err != nil {
-			log.WithError(err).Warn("Cannot create container")
-			continue
+		if notStarted {
+			clog.Infof("Container %s was re-created but not started", trimID(created.ID))
+		} else if strategy == StrategyRecreate {
+			clog.Infof("Starting container %s", trimID(created.ID))
+			setPhase("start " + trimID(created.ID))
+			if err = dc.ContainerStart(dctx, created.ID, types.ContainerStartOptions{}); err != nil {
+				setPhase("restore " + trimID(cont.ID))
+				part.Failed = append(part.Failed, snapshot.restoreFailed(dctx, cont, created.ID, FailStart, err))
+				part.outcome(cont, ActionFailed, FailStart, err)
+				return false
+			}
+
+			// wait for container to become healthy
+			if expected.healthTimeout > 0 {
+				setPhase("health " + trimID(created.ID))
+				if err = expected.waitHealthy(dctx, created.ID); err != nil {
+					clog.WithError(err).Warnf("Container %s did not become healthy", trimID(created.ID))
+					if expected.healthRollback {
+						setPhase("restore " + trimID(cont.ID))
+						part.Failed = append(part.Failed, snapshot.restoreFailed(dctx, cont, created.ID, FailUnhealthy, err))
+						part.outcome(cont, ActionFailed, FailUnhealthy, err)
+						return false
+					}
+					unhealthy = &failedContainer{ID: created.ID, Image: cont.Image, Reason: FailUnhealthy, Error: err.Error()}
+				}
+			}
+		}
+
+		// timestamps proving the container was re-created
+		var previousStartedAt, startedAt, newImageID string
+		if inspect.State != nil {
+			previousStartedAt = inspect.State.StartedAt
+		}
+		if createdInspect, err := dc.ContainerInspect(dctx, created.ID); err != nil {
+			clog.WithError(err).Warnf("Cannot inspect re-created container %s", trimID(created.ID))
+		} else {
+			newImageID = createdInspect.Image
+			if createdInspect.State != nil && !notStarted {
+				startedAt = createdInspect.State.StartedAt
+			}
 		}
 
-		log.Infof("Starting container %s", created.ID)
-		if err = dc.ContainerStart(context.Background(), created.ID, types.ContainerStartOptions{}); err != nil {
-			log.WithError(err).Warn("Cannot start container")
-			continue
+		// ID of the pulled image, the old image is only kept for rollbacks or removed if it was replaced
+		var currentImageID string
+		if img, _, err := dc.ImageInspectWithRaw(dctx, inspect.Config.Image); err != nil {
+			clog.WithError(err).Warnf("Cannot inspect image %s", inspect.Config.Image)
+		} else {
+			currentImageID = img.ID
 		}
 
 		// auto delete old image
-		if expected.removeOld {
-			// quite hacky, is there a better way?
-			if strings.Contains(strings.ToLower(string(body)), cont.ImageID) {
-				log.Infof("It looks like the old image was pulled again. Skipped removing.")
+		removed := false
+		if expected.removeOld && !expected.prune && !expected.atomic && expected.mode != ModeRestartOnly {
+			if currentImageID == "" || currentImageID == cont.ImageID {
+				clog.Infof("The old image is still the current image. Skipped removing.")
+			} else if inUse, err := imageInUse(dctx, cont.ImageID); err != nil {
+				clog.WithError(err).Warn("Cannot check if the old image is still in use")
+			} else if inUse {
+				clog.Infof("The old image is still used by other containers. Skipped removing.")
 			} else {
-				log.Infof("Deleting image %s", cont.ImageID)
-				if err = deleteImage(cont.ImageID); err != nil {
-					log.WithError(err).Warn("Cannot remove old image")
+				clog.Infof("Deleting image %s", trimID(cont.ImageID))
+				if unlock, err := lockImage(dctx, cont.Image, holder); err != nil {
+					clog.WithError(err).Warn("Cannot lock old image")
+				} else {
+					err = deleteImage(dctx, cont.ImageID)
+					unlock()
+					if err != nil {
+						clog.WithError(err).Warn("Cannot remove old image")
+					} else {
+						removed = true
+					}
 				}
 			}
 		}
 
-		log.Infof("Done! Container with image (%s) updated", cont.Image)
-		restarted = append(restarted, cont)
+		// remember old image for rollbacks
+		key := containerKey(cont.Names, cont.ID)
+		if isRollback {
+			forgetPrevious(key)
+		} else if !removed && currentImageID != "" && currentImageID != cont.ImageID {
+			recordPrevious(key, cont.ImageID)
+		}
+
+		clog.Infof("Done! Container with image (%s) updated", cont.Image)
+		metricRestarted.WithLabelValues(name).Inc()
+		if expected.atomic {
+			mu.Lock()
+			snapshots[created.ID] = snapshot
+			mu.Unlock()
+		}
+		part.restart(restartedContainer{
+			Container:    cont,
+			Resources:    resources,
+			Restarting:   restarting,
+			NotStarted:   notStarted,
+			Signature:    signature,
+			Warnings:     warnings,
+			RolledBackTo: rollbackTo.ImageID,
+			Strategy:     strategy,
+
+			NewID:             created.ID,
+			NewImageID:        newImageID,
+			PreviousStartedAt: previousStartedAt,
+			StartedAt:         startedAt,
+		})
+		if unhealthy != nil {
+			part.unstable([]failedContainer{*unhealthy})
+		}
+		return false
+	}
+
+	// outcomes of each container, merged in the order the containers were matched
+	parts := make([]*UpdateResult, len(containerList))
+	if expected.concurrency <= 1 {
+		for i, cont := range containerList {
+			if dctx.Err() != nil {
+				break
+			}
+			parts[i] = new(UpdateResult)
+			if update(cont, parts[i]) {
+				break
+			}
+		}
+	} else {
+		var (
+			wg      sync.WaitGroup
+			aborted int32
+			sem     = make(chan struct{}, expected.concurrency)
+		)
+	dispatch:
+		for i, cont := range containerList {
+			select {
+			case sem <- struct{}{}:
+			case <-dctx.Done():
+				break dispatch
+			}
+			if dctx.Err() != nil || atomic.LoadInt32(&aborted) != 0 {
+				break
+			}
+			parts[i] = new(UpdateResult)
+			wg.Add(1)
+			go func(cont types.Container, part *UpdateResult) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				if update(cont, part) {
+					atomic.StoreInt32(&aborted, 1)
+				}
+			}(cont, parts[i])
+		}
+		wg.Wait()
+	}
+	for _, part := range parts {
+		if part != nil {
+			result.merge(part)
+		}
+	}
+
+	// containers not reached because the update was interrupted
+	result.abort(containerList)
+
+	// check that the containers keep running
+	if expected.stabilization > 0 && len(result.Restarted) > 0 && dctx.Err() == nil {
+		setPhase("stabilization")
+		result.unstable(expected.stabilize(dctx, result.Restarted))
+	}
+
+	switch dctx.Err() {
+	case context.DeadlineExceeded:
+		logger.Warnf("Update of %s exceeded %s during %s", name, expected.maxDuration, phase)
+		result.Interrupted = &interruption{Reason: InterruptTimeout, Phase: phase,
+			Error: fmt.Sprintf("update exceeded %s (interrupted during %s)", expected.maxDuration, phase)}
+	case context.Canceled:
+		logger.Warnf("Update of %s was cancelled by the shutdown during %s", name, phase)
+		result.Interrupted = &interruption{Reason: InterruptShutdown, Phase: phase,
+			Error: fmt.Sprintf("update cancelled by shutdown (interrupted during %s)", phase)}
+	}
+
+	// an atomic update either updates all containers or none
+	if expected.atomic && len(result.Restarted) > 0 && (result.Interrupted != nil || len(result.Failed) > 0) {
+		cause := "another container of the update failed"
+		if result.Interrupted != nil {
+			cause = result.Interrupted.Error
+		}
+		logger.Warnf("Rolling back %d containers of the atomic update: %s", len(result.Restarted), cause)
+		setPhase("atomic rollback")
+		result.rollBackAll(snapshots, cause)
+	}
+	if result.Interrupted != nil {
+		return result, nil
+	}
+
+	// restart dependent containers
+	if expected.cascade && len(result.Restarted) > 0 {
+		result.Cascaded = cascade(dctx, result.Restarted, holder)
+	}
+
+	// reclaim the space of replaced images
+	if expected.prune && expected.mode != ModeRestartOnly && len(result.Restarted) > 0 {
+		setPhase("prune")
+		if report, err := pruneImages(dctx); err != nil {
+			logger.WithError(err).Warn("Cannot prune images")
+		} else {
+			logger.Infof("Pruned %d images, reclaimed %d bytes", len(report.ImagesDeleted), report.SpaceReclaimed)
+			result.SpaceReclaimed = report.SpaceReclaimed
+		}
+	}
+
+	if len(needApproval) > 0 {
+		result.Approval = requestApproval(name, needApproval)
+	}
+
+	if len(result.Restarted) > 0 {
+		ev := &deployEvent{
+			Webhook:   name,
+			Time:      time.Now(),
+			Restarted: result.Restarted,
+		}
+		for _, r := range result.Restarted {
+			ev.Images = append(ev.Images, r.Image)
+		}
+		emitEvent(ev)
 	}
+	expected.notifySlack(name, result)
 
-	return ctx.Status(200).JSON(restarted)
+	return result, nil
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"net/http"
+	"testing"
+)
+
+func TestSecretEqual(t *testing.T) {
+	tests := []struct {
+		name            string
+		given, expected string
+		equal           bool
+	}{
+		{"equal", "s3cr3t", "s3cr3t", true},
+		{"unequal", "s3cr3x", "s3cr3t", false},
+		{"prefix", "s3cr", "s3cr3t", false},
+		{"longer", "s3cr3t-and-more", "s3cr3t", false},
+		{"empty given", "", "s3cr3t", false},
+		{"empty expected", "s3cr3t", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := secretEqual(tt.given, tt.expected); got != tt.equal {
+				t.Errorf("secretEqual(%q, %q) = %v, want %v", tt.given, tt.expected, got, tt.equal)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	attrs["AUTH"] = &attributes{secret: "s3cr3t"}
+	t.Cleanup(func() { delete(attrs, "AUTH") })
+
+	if _, err := authorize("AUTH", "s3cr3t"); err != nil {
+		t.Errorf("expected the secret to be accepted, got %v", err)
+	}
+	if _, err := authorize("AUTH", "wrong"); err != ErrSecretInvalid {
+		t.Errorf("expected ErrSecretInvalid, got %v", err)
+	}
+	if _, err := authorize("MISSING", "s3cr3t"); err != ErrWebhookNotFound {
+		t.Errorf("expected ErrWebhookNotFound, got %v", err)
+	}
+}
+
+func TestPullImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		failed  bool
+		authErr bool
+	}{
+		{"pulled", http.StatusOK, `{"status":"Downloaded newer image for app:latest"}`, false, false},
+		{"daemon error", http.StatusInternalServerError, `{"message":"pull failed"}`, true, false},
+		{"not found", http.StatusNotFound, `{"message":"manifest for app:latest not found"}`, true, false},
+		{"unauthorized", http.StatusUnauthorized, `{"message":"authentication required"}`, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/images/create" {
+					notFound(w)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			body, err := (&attributes{}).pullImage(context.Background(), &types.Container{Image: "app:latest"})
+			if !tt.failed {
+				if err != nil {
+					t.Fatalf("expected the pull to succeed, got %v", err)
+				}
+				if string(body) != tt.body {
+					t.Errorf("expected body %q, got %q", tt.body, body)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected the pull to fail")
+			}
+			if body != nil {
+				t.Errorf("expected no body, got %q", body)
+			}
+			if got := errors.Is(err, errAuthFailed); got != tt.authErr {
+				t.Errorf("expected errors.Is(err, errAuthFailed) = %v, got %v (%v)", tt.authErr, got, err)
+			}
+		})
+	}
+}
+
+func TestImageInUse(t *testing.T) {
+	const oldImage = "sha256:old"
+	tests := []struct {
+		name       string
+		containers []types.Container
+		status     int
+		inUse      bool
+		failed     bool
+	}{
+		{"used by another container", []types.Container{
+			{ID: "replacement", ImageID: "sha256:new"},
+			{ID: "worker", ImageID: oldImage, State: "exited"},
+		}, http.StatusOK, true, false},
+		{"unused", []types.Container{
+			{ID: "replacement", ImageID: "sha256:new"},
+		}, http.StatusOK, false, false},
+		{"no containers", nil, http.StatusOK, false, false},
+		{"daemon error", nil, http.StatusInternalServerError, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/containers/json" {
+					notFound(w)
+					return
+				}
+				// stopped containers keep their image as well
+				if r.URL.Query().Get("all") != "1" {
+					t.Errorf("expected all containers to be listed, got %s", r.URL.RawQuery)
+				}
+				if tt.status != http.StatusOK {
+					writeJSON(w, tt.status, map[string]string{"message": "list failed"})
+					return
+				}
+				writeJSON(w, tt.status, tt.containers)
+			})
+			inUse, err := imageInUse(context.Background(), oldImage)
+			if tt.failed != (err != nil) {
+				t.Fatalf("expected failed = %v, got %v", tt.failed, err)
+			}
+			if inUse != tt.inUse {
+				t.Errorf("expected in use = %v, got %v", tt.inUse, inUse)
+			}
+		})
+	}
+}
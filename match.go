@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"strings"
+	"time"
+)
+
+// matchEnv returns the variables a match expression is evaluated with
+func matchEnv(cont *types.Container, inspect *types.ContainerJSON) map[string]interface{} {
+	var (
+		names        = make([]string, 0, len(cont.Names))
+		running      bool
+		restartCount int
+		uptime       float64
+	)
+	for _, n := range cont.Names {
+		names = append(names, strings.TrimPrefix(n, "/"))
+	}
+	name := ""
+	if len(names) > 0 {
+		name = names[0]
+	}
+	tag := ""
+	if named, err := reference.ParseNormalizedNamed(cont.Image); err == nil {
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+	}
+	labels := cont.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if inspect != nil && inspect.ContainerJSONBase != nil {
+		restartCount = inspect.RestartCount
+		if inspect.State != nil {
+			running = inspect.State.Running
+			if started, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt); err == nil && running {
+				uptime = time.Since(started).Seconds()
+			}
+		}
+	}
+	return map[string]interface{}{
+		"id":            cont.ID,
+		"name":          name,
+		"names":         names,
+		"image":         cont.Image,
+		"tag":           tag,
+		"labels":        labels,
+		"state":         cont.State,
+		"status":        cont.Status,
+		"running":       running,
+		"restart_count": restartCount,
+		"uptime":        uptime,                     // seconds since the container was started
+		"created":       float64(cont.Created),      // unix time in seconds
+		"now":           float64(time.Now().Unix()), // unix time in seconds
+		"duration":      parseSeconds,               // e.g. duration("168h")
+	}
+}
+
+// parseSeconds parses a duration and returns it in seconds
+func parseSeconds(s string) float64 {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		panic(err) // reported as evaluation error
+	}
+	return d.Seconds()
+}
+
+// compileMatch compiles a match expression, it has to return a bool
+func compileMatch(code string) (*vm.Program, error) {
+	return expr.Compile(code, expr.Env(matchEnv(&types.Container{}, nil)), expr.AsBool())
+}
+
+// matches evaluates the match expression of a webhook against a container
+func (a *attributes) matches(dctx context.Context, cont *types.Container) (bool, error) {
+	if a.match == nil {
+		return true, nil
+	}
+	inspect, err := dc.ContainerInspect(dctx, cont.ID)
+	if err != nil {
+		return false, err
+	}
+	out, err := expr.Run(a.match, matchEnv(cont, &inspect))
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := out.(bool)
+	if !isBool {
+		return false, fmt.Errorf("expression returned %T instead of bool", out)
+	}
+	return ok, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// matchedContainer is a container a webhook would update
+type matchedContainer struct {
+	ID    string   `json:"id"`
+	Names []string `json:"names"`
+	Image string   `json:"image"`
+	State string   `json:"state"`
+}
+
+// matched returns the containers a webhook currently matches without updating anything
+func matched(name, secret string, ctx *fiber.Ctx) (err error) {
+	name = strings.TrimSpace(name)
+	if _, err = authorize(name, strings.TrimSpace(secret)); err != nil {
+		return
+	}
+
+	var containerList []types.Container
+	if containerList, err = matchingContainers(ctx.Context(), name); err != nil {
+		return fiber.NewError(500, err.Error())
+	}
+
+	result := make([]matchedContainer, 0, len(containerList))
+	for _, cont := range containerList {
+		result = append(result, matchedContainer{
+			ID:    cont.ID,
+			Names: cont.Names,
+			Image: cont.Image,
+			State: cont.State,
+		})
+	}
+	return ctx.JSON(result)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"sync/atomic"
+)
+
+var (
+	metricRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yadwh_webhook_requests_total",
+		Help: "Authorized triggers of a webhook",
+	}, []string{"name"})
+	metricRestarted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yadwh_containers_restarted_total",
+		Help: "Containers re-created by a webhook",
+	}, []string{"name"})
+	metricPullErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yadwh_pull_errors_total",
+		Help: "Failed image pulls of a webhook",
+	}, []string{"name"})
+	metricDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "yadwh_webhook_duration_seconds",
+		Help:    "Processing duration of a webhook trigger",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10), // 0.5s to ~4m
+	}, []string{"name"})
+	metricRecords = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yadwh_records",
+		Help: "Records kept after the last sweep, e.g. jobs or history entries",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(metricRequests, metricRestarted, metricPullErrors, metricDuration, metricRecords)
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "yadwh_lock_contended_total",
+		Help:        "Lock acquisitions which had to wait for another operation",
+		ConstLabels: prometheus.Labels{"kind": "container"},
+	}, func() float64 {
+		return float64(atomic.LoadUint64(&containerLocks.contended))
+	}))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "yadwh_lock_contended_total",
+		Help:        "Lock acquisitions which had to wait for another operation",
+		ConstLabels: prometheus.Labels{"kind": "image"},
+	}, func() float64 {
+		return float64(atomic.LoadUint64(&imageLocks.contended))
+	}))
+}
+
+// handleMetrics serves the Prometheus metrics
+func handleMetrics() fiber.Handler {
+	h := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(ctx *fiber.Ctx) error {
+		h(ctx.Context())
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"os"
+	"strings"
+)
+
+// loadTLSConfig returns the TLS config of the webhook listener or nil if TLS is not enabled.
+// Certificate and key must be set together.
+// If a client CA is set, client certificates are verified if presented
+func loadTLSConfig() (*tls.Config, error) {
+	certFile := strings.TrimSpace(os.Getenv(EnvTLSCert))
+	keyFile := strings.TrimSpace(os.Getenv(EnvTLSKey))
+	caFile := strings.TrimSpace(os.Getenv(EnvTLSClientCA))
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, fmt.Errorf("%s requires %s and %s", EnvTLSClientCA, EnvTLSCert, EnvTLSKey)
+		}
+		return nil, nil
+	}
+	if certFile == "" {
+		return nil, fmt.Errorf("%s requires %s", EnvTLSKey, EnvTLSCert)
+	}
+	if keyFile == "" {
+		return nil, fmt.Errorf("%s requires %s", EnvTLSCert, EnvTLSKey)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.ClientCAs = pool
+		// webhooks authorized by secret don't need a client certificate
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// clientCertificate returns the verified client certificate of the request or nil
+func clientCertificate(ctx *fiber.Ctx) *x509.Certificate {
+	state := ctx.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// certIdentities returns the subject, common name and SANs of a certificate
+func certIdentities(cert *x509.Certificate) (ids []string) {
+	ids = append(ids, cert.Subject.String())
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return
+}
+
+// authorizeCert checks if the verified client certificate of the request is allowed to trigger the webhook name
+func authorizeCert(name string, ctx *fiber.Ctx) (*attributes, error) {
+	expected, ok := attrs[name]
+	if !ok || expected == nil {
+		return nil, ErrWebhookNotFound
+	}
+	cert := clientCertificate(ctx)
+	if cert == nil || len(expected.mtls) == 0 {
+		return nil, ErrSecretInvalid
+	}
+	for _, id := range certIdentities(cert) {
+		for _, allowed := range expected.mtls {
+			if id == allowed {
+				return expected, nil
+			}
+		}
+	}
+	return nil, ErrSecretInvalid
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/apex/log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// notifyTimeout is the timeout of a single attempt of an outgoing notification
+var notifyTimeout = 10 * time.Second
+
+// postJSON sends body as JSON to url, retrying with notifyRetry on errors and non-2xx responses
+func postJSON(dctx context.Context, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return notifyRetry.do(dctx, func(dctx context.Context) error {
+		dctx, cancel := context.WithTimeout(dctx, notifyTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(dctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// isHTTPURL checks if v is an absolute http(s) URL
+func isHTTPURL(v string) bool {
+	u, err := url.Parse(v)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// notifiedContainer is an updated container in an update notification
+type notifiedContainer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Image      string `json:"image"`
+	OldImageID string `json:"old_image_id"`
+	NewImageID string `json:"new_image_id"`
+}
+
+// updateNotification is posted to the notify URL of a webhook after each update
+type updateNotification struct {
+	Webhook  string              `json:"webhook"`
+	Time     time.Time           `json:"time"`
+	Updated  []notifiedContainer `json:"updated"`
+	Rejected []rejectedContainer `json:"rejected,omitempty"`
+	Failed   []failedContainer   `json:"failed,omitempty"`
+	// Error is set if the whole update failed
+	Error string `json:"error,omitempty"`
+}
+
+// notifyUpdate posts the result of an update to the notify URL of a in the background
+func (a *attributes) notifyUpdate(name string, result *UpdateResult, err error) {
+	if a.notifyURL == "" {
+		return
+	}
+	n := &updateNotification{Webhook: name, Time: time.Now(), Updated: []notifiedContainer{}}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	if result != nil {
+		for _, r := range result.Restarted {
+			n.Updated = append(n.Updated, notifiedContainer{
+				ID:         r.NewID,
+				Name:       containerKey(r.Names, r.ID),
+				Image:      r.Image,
+				OldImageID: r.ImageID,
+				NewImageID: r.NewImageID,
+			})
+		}
+		n.Rejected, n.Failed = result.Rejected, result.Failed
+	}
+	go func() {
+		if err := postJSON(shutdownCtx, a.notifyURL, n); err != nil {
+			log.WithError(err).Warnf("Cannot send update notification of %s", name)
+		}
+	}()
+}
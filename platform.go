@@ -0,0 +1,23 @@
+package main
+
+import (
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"regexp"
+	"strings"
+)
+
+// validPlatform matches os/arch[/variant], e.g. linux/arm64/v8
+var validPlatform = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// platformSpec returns the platform containers of the webhook are created for, nil for the platform of the host
+func (a *attributes) platformSpec() *specs.Platform {
+	if a.platform == "" {
+		return nil
+	}
+	parts := strings.Split(a.platform, "/")
+	p := &specs.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"time"
+)
+
+// startPolling checks the registry for new images of the webhook name every interval until shutdown
+func startPolling(name string, a *attributes) {
+	go func() {
+		ticker := time.NewTicker(a.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shutdownCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if isPaused() || isDraining() {
+				continue
+			}
+			poll(name, a)
+		}
+	}()
+}
+
+// poll updates the containers of the webhook name if the registry has a different image for any of them.
+// Polls are skipped while an update of the webhook is running
+func poll(name string, a *attributes) {
+	requestID := randomID(4)
+	logger := log.WithField("req", requestID).WithField("webhook", name)
+
+	containerList, err := matchingContainers(shutdownCtx, name)
+	if err != nil {
+		logger.WithError(err).Warn("Cannot list containers to poll")
+		return
+	}
+	changed := false
+	for _, cont := range containerList {
+		digest, err := remoteDigest(shutdownCtx, cont.Image, a.imageAuth(shutdownCtx, cont.Image))
+		if err != nil {
+			logger.WithError(err).Warnf("Cannot check registry for %s", cont.Image)
+			continue
+		}
+		current, err := hasDigest(shutdownCtx, cont.ImageID, digest)
+		if err != nil {
+			logger.WithError(err).Warnf("Cannot inspect image %s", trimID(cont.ImageID))
+			continue
+		}
+		if !current {
+			logger.Infof("Found new image for %s", cont.Image)
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		logger.Debug("Poll found no new images")
+		return
+	}
+
+	untrack, ok := track(name)
+	if !ok {
+		return
+	}
+	defer untrack()
+
+	// only one update per webhook at a time
+	if !a.lock.tryLock() {
+		logger.Info("Update already running, skipping poll")
+		return
+	}
+	defer a.lock.unlock()
+	u := &updateRun{
+		name:      name,
+		expected:  a,
+		req:       new(updateRequest),
+		requestID: requestID,
+		logger:    logger,
+		dryRun:    a.dryRun,
+	}
+	result, err := u.run()
+	if err != nil {
+		logger.WithError(err).Warn("Polled update failed")
+		return
+	}
+	logger.Infof("Polled update restarted %d containers", len(result.Restarted))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"sort"
+	"sync"
+)
+
+// prewarm pulls the images of the containers matched by all webhooks in the background.
+// Images are pulled like by an update of the webhook, with its credentials, platform and concurrency
+func prewarm(dctx context.Context) {
+	var names []string
+	for name, a := range attrs {
+		// restart-only webhooks never pull
+		if a.mode != ModeRestartOnly {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// each image is pulled once per platform, by the first webhook matching it
+	pulled := make(map[string]bool)
+	for _, name := range names {
+		if dctx.Err() != nil {
+			return
+		}
+		prewarmWebhook(dctx, name, attrs[name], pulled)
+	}
+	log.Info("Pre-warming finished")
+}
+
+// prewarmWebhook pulls the images of the containers matched by the webhook name which are not in pulled yet
+func prewarmWebhook(dctx context.Context, name string, a *attributes, pulled map[string]bool) {
+	containerList, err := matchingContainers(dctx, name)
+	if err != nil {
+		log.WithError(err).Warnf("Cannot list containers of %s to pre-warm", name)
+		return
+	}
+	var images []types.Container
+	for _, cont := range containerList {
+		if key := a.platform + "|" + cont.Image; !pulled[key] {
+			pulled[key] = true
+			images = append(images, cont)
+		}
+	}
+	if len(images) == 0 {
+		return
+	}
+	log.Infof("Pre-warming %d images of %s", len(images), name)
+
+	concurrency := a.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for i := range images {
+		select {
+		case sem <- struct{}{}:
+		case <-dctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(i int, cont *types.Container) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := prewarmImage(dctx, a, cont); err != nil {
+				log.WithError(err).Warnf("Cannot pre-warm image %s", cont.Image)
+				return
+			}
+			log.Infof("Pre-warmed image %s (%d/%d)", cont.Image, i+1, len(images))
+		}(i, &images[i])
+	}
+	wg.Wait()
+}
+
+// prewarmImage pulls the image of cont with the settings of the webhook a
+func prewarmImage(dctx context.Context, a *attributes, cont *types.Container) error {
+	unlock, err := lockImage(dctx, cont.Image, "prewarm")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, err = a.pullImage(dctx, cont)
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestPrewarm(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		pulls []string
+	)
+	fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/json":
+			writeJSON(w, http.StatusOK, []types.Container{
+				{ID: "api", Image: "api:latest", Labels: map[string]string{LabelKey: "ARM,WEB"}},
+				{ID: "web", Image: "web:latest", Labels: map[string]string{LabelKey: "WEB"}},
+				{ID: "db", Image: "db:latest", Labels: map[string]string{LabelKey: "RESTART"}},
+			})
+		case "/images/create":
+			q := r.URL.Query()
+			mu.Lock()
+			pulls = append(pulls, q.Get("fromImage")+":"+q.Get("tag")+"@"+q.Get("platform"))
+			mu.Unlock()
+			writeJSON(w, http.StatusOK, map[string]string{"status": "Downloaded"})
+		default:
+			notFound(w)
+		}
+	})
+	for name, a := range map[string]*attributes{
+		"ARM":     {mode: ModeUpdate, platform: "linux/arm64", concurrency: 1},
+		"WEB":     {mode: ModeUpdate, concurrency: 2},
+		"RESTART": {mode: ModeRestartOnly, concurrency: 1},
+	} {
+		attrs[name] = a
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{"ARM", "WEB", "RESTART"} {
+			delete(attrs, name)
+		}
+	})
+
+	prewarm(context.Background())
+
+	// images are pulled for the platform of each webhook, restart-only webhooks don't pull
+	sort.Strings(pulls)
+	want := []string{"api:latest@", "api:latest@linux/arm64", "web:latest@"}
+	if len(pulls) != len(want) {
+		t.Fatalf("expected pulls %v, got %v", want, pulls)
+	}
+	for i := range want {
+		if pulls[i] != want[i] {
+			t.Errorf("expected pulls %v, got %v", want, pulls)
+			break
+		}
+	}
+}
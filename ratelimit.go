@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/distribution/reference"
+	"golang.org/x/time/rate"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backoff after a registry answered with 429
+const (
+	minRegistryBackoff = 5 * time.Second
+	maxRegistryBackoff = 5 * time.Minute
+)
+
+// registryRPS limits the manifest checks per registry and second, 0 = unlimited
+var registryRPS float64
+
+// registryBucket limits the requests to a single registry
+type registryBucket struct {
+	limiter      *rate.Limiter
+	backoff      time.Duration
+	blockedUntil time.Time
+}
+
+var (
+	registryBuckets   = make(map[string]*registryBucket)
+	registryBucketsMu sync.Mutex
+)
+
+// registryOf returns the registry host of the image ref
+func registryOf(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.Domain(named)
+}
+
+func bucketFor(registry string) *registryBucket {
+	registryBucketsMu.Lock()
+	defer registryBucketsMu.Unlock()
+	b, ok := registryBuckets[registry]
+	if !ok {
+		limit, burst := rate.Inf, 1
+		if registryRPS > 0 {
+			limit = rate.Limit(registryRPS)
+			if burst = int(registryRPS); burst < 1 {
+				burst = 1
+			}
+		}
+		b = &registryBucket{limiter: rate.NewLimiter(limit, burst)}
+		registryBuckets[registry] = b
+	}
+	return b
+}
+
+// waitRegistry waits until a manifest check of ref is allowed by the rate limit and backoff of its registry
+func waitRegistry(dctx context.Context, ref string) error {
+	registry := registryOf(ref)
+	b := bucketFor(registry)
+
+	registryBucketsMu.Lock()
+	wait := time.Until(b.blockedUntil)
+	registryBucketsMu.Unlock()
+	if wait > 0 {
+		log.Debugf("Registry %s is rate limited, waiting %s", registry, wait.Round(time.Second))
+		select {
+		case <-dctx.Done():
+			return fmt.Errorf("registry %s is rate limited: %w", registry, dctx.Err())
+		case <-time.After(wait):
+		}
+	}
+	return b.limiter.Wait(dctx)
+}
+
+// isRateLimited checks if err was caused by a 429 of the registry
+func isRateLimited(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "toomanyrequests") || strings.Contains(msg, "429")
+}
+
+// registryDone updates the backoff of the registry of ref after a manifest check
+func registryDone(ref string, err error) {
+	registry := registryOf(ref)
+	b := bucketFor(registry)
+
+	registryBucketsMu.Lock()
+	defer registryBucketsMu.Unlock()
+	if err == nil || !isRateLimited(err) {
+		b.backoff = 0
+		return
+	}
+	if b.backoff *= 2; b.backoff < minRegistryBackoff {
+		b.backoff = minRegistryBackoff
+	} else if b.backoff > maxRegistryBackoff {
+		b.backoff = maxRegistryBackoff
+	}
+	b.blockedUntil = time.Now().Add(b.backoff)
+	log.Warnf("Registry %s answered with 429, backing off for %s", registry, b.backoff)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"github.com/gofiber/fiber/v2"
+	"runtime/debug"
+)
+
+// recoverPanic is a middleware which answers requests that panicked with 500 instead of crashing the server.
+// The panic is logged with the request ID and the webhook
+func recoverPanic(ctx *fiber.Ctx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("req", string(ctx.Response().Header.Peek(HeaderRequestID))).
+				WithField("webhook", ctx.Params("name")).
+				Errorf("Recovered from panic in %s %s: %v\n%s", ctx.Method(), ctx.Path(), r, debug.Stack())
+			err = fiber.NewError(fiber.StatusInternalServerError, "internal error")
+		}
+	}()
+	return ctx.Next()
+}
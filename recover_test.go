@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	app := fiber.New()
+	app.Use(recoverPanic)
+	app.Get("/panic", func(ctx *fiber.Ctx) error {
+		var a *attributes
+		return ctx.SendString(a.secret)
+	})
+	app.Get("/ok", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Errorf("expected status 500 after the panic, got %d", resp.StatusCode)
+	}
+
+	// the server keeps serving
+	if resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/ok", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("expected status 200 after the panic, got %d", resp.StatusCode)
+	}
+}
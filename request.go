@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+	"github.com/gofiber/fiber/v2"
+	"regexp"
+	"strings"
+)
+
+// updateRequest contains optional settings which can be passed as a JSON body
+type updateRequest struct {
+	Memory string   `json:"memory,omitempty"` // memory limit, e.g. 512m
+	CPUs   float64  `json:"cpus,omitempty"`   // number of CPUs, e.g. 1.5
+	Ports  []string `json:"ports,omitempty"`  // published ports, e.g. 8080:80
+
+	NoStart bool `json:"no_start,omitempty"` // re-create containers without starting them
+	Force   bool `json:"force,omitempty"`    // re-create containers which are already deployed or up to date
+
+	Tag string `json:"tag,omitempty"` // tag deployed instead of the current tag of the containers, e.g. a commit SHA
+}
+
+// anchoredTag matches a valid image tag
+var anchoredTag = regexp.MustCompile("^" + reference.TagRegexp.String() + "$")
+
+// appliedResources contains the resource limits applied to a re-created container
+type appliedResources struct {
+	Memory   int64       `json:"memory,omitempty"`
+	NanoCPUs int64       `json:"nano_cpus,omitempty"`
+	Ports    nat.PortMap `json:"ports,omitempty"`
+}
+
+// portRange is an inclusive range of host ports
+type portRange struct {
+	start, end uint64
+}
+
+// parsePortRanges parses a comma separated list of ports and port ranges (e.g. 8000-8999)
+func parsePortRanges(v string) (ranges []portRange, err error) {
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		var r portRange
+		if r.start, r.end, err = nat.ParsePortRange(p); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return
+}
+
+// allowsPort checks if the host port is in the allowed port ranges of the webhook
+func (a *attributes) allowsPort(port string) bool {
+	p, err := nat.ParsePort(port)
+	if err != nil || p == 0 {
+		return false
+	}
+	for _, r := range a.allowPorts {
+		if uint64(p) >= r.start && uint64(p) <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// isJSON checks if the body of ctx is a JSON update request
+func isJSON(ctx *fiber.Ctx) bool {
+	return strings.HasPrefix(string(ctx.Request().Header.ContentType()), fiber.MIMEApplicationJSON)
+}
+
+// parseUpdateRequest reads the JSON body of ctx (if any)
+func parseUpdateRequest(ctx *fiber.Ctx) (req *updateRequest, err error) {
+	req = new(updateRequest)
+	if !isJSON(ctx) {
+		return
+	}
+	if len(ctx.Body()) == 0 {
+		return
+	}
+	if err = json.Unmarshal(ctx.Body(), req); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "invalid request body: "+err.Error())
+	}
+	if req.Tag != "" && !anchoredTag.MatchString(req.Tag) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "invalid tag: "+req.Tag)
+	}
+	return
+}
+
+// resources validates the requested resource limits against the allowlist of the webhook
+func (a *attributes) resources(req *updateRequest) (res *appliedResources, err error) {
+	if req.Memory == "" && req.CPUs == 0 && len(req.Ports) == 0 {
+		return nil, nil
+	}
+	res = new(appliedResources)
+	if req.Memory != "" {
+		if !a.allowResources["memory"] {
+			return nil, fiber.NewError(fiber.StatusForbidden, "changing memory is not allowed for this webhook")
+		}
+		if res.Memory, err = units.RAMInBytes(req.Memory); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid memory: "+err.Error())
+		}
+		// docker does not allow less than 6MB
+		if res.Memory < 6*units.MiB {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid memory: minimum is 6m")
+		}
+	}
+	if req.CPUs != 0 {
+		if !a.allowResources["cpus"] {
+			return nil, fiber.NewError(fiber.StatusForbidden, "changing cpus is not allowed for this webhook")
+		}
+		if req.CPUs < 0.01 {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid cpus: %v", req.CPUs))
+		}
+		res.NanoCPUs = int64(req.CPUs * 1e9)
+	}
+	if len(req.Ports) > 0 {
+		if len(a.allowPorts) == 0 {
+			return nil, fiber.NewError(fiber.StatusForbidden, "changing ports is not allowed for this webhook")
+		}
+		if _, res.Ports, err = nat.ParsePortSpecs(req.Ports); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid ports: "+err.Error())
+		}
+		for port, bindings := range res.Ports {
+			for _, b := range bindings {
+				if !a.allowsPort(b.HostPort) {
+					return nil, fiber.NewError(fiber.StatusForbidden,
+						fmt.Sprintf("publishing %s on host port %q is not allowed for this webhook", port, b.HostPort))
+				}
+			}
+		}
+	}
+	return
+}
+
+// apply writes the resource limits and port bindings to the config and host config of a container
+func (r *appliedResources) apply(config *container.Config, hc *container.HostConfig) error {
+	if r == nil {
+		return nil
+	}
+	if hc == nil {
+		return errors.New("container has no host config")
+	}
+	if r.Memory > 0 {
+		hc.Memory = r.Memory
+		// swap must not be lower than memory
+		if hc.MemorySwap > 0 && hc.MemorySwap < r.Memory {
+			hc.MemorySwap = 0
+		}
+	}
+	if r.NanoCPUs > 0 {
+		// nano cpus conflicts with cpu period and quota
+		hc.NanoCPUs = r.NanoCPUs
+		hc.CPUPeriod, hc.CPUQuota = 0, 0
+	}
+	if len(r.Ports) > 0 {
+		// replaces the bindings of the requested container ports only
+		if hc.PortBindings == nil {
+			hc.PortBindings = make(nat.PortMap)
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = make(nat.PortSet)
+		}
+		for port, bindings := range r.Ports {
+			hc.PortBindings[port] = bindings
+			config.ExposedPorts[port] = struct{}{}
+		}
+	}
+	return nil
+}
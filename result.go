@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// reasons for rejecting an image before deploying it
+const (
+	RejectSignatureInvalid = "signature-invalid"
+	RejectAuthFailed       = "auth-failed"
+)
+
+// reasons for failing a container
+const (
+	FailUnstable     = "unstable"
+	FailNameConflict = "name-conflict"
+	FailStart        = "start-failed"
+	FailUnhealthy    = "unhealthy"
+	FailLock         = "lock-failed"
+	FailPull         = "pull-failed"
+	FailVerify       = "verify-failed"
+	FailInspect      = "inspect-failed"
+	FailPrepare      = "prepare-failed"
+	FailStop         = "stop-failed"
+	FailRemove       = "remove-failed"
+	FailCreate       = "create-failed"
+	FailAtomic       = "atomic-rollback"
+)
+
+// reasons for interrupting an update
+const (
+	InterruptTimeout  = "timeout"
+	InterruptShutdown = "shutdown"
+)
+
+// reasons for skipping a container
+const (
+	SkipUnchanged  = "unchanged"
+	SkipDeployed   = "already-deployed"
+	SkipNoPrevious = "no-previous-image"
+	SkipNotLoaded  = "not-loaded"
+	SkipRestarting = "restarting"
+)
+
+// actions taken for a matched container
+const (
+	ActionUpdated  = "updated"
+	ActionSkipped  = "skipped"
+	ActionRejected = "rejected"
+	ActionFailed   = "failed"
+	ActionPlanned  = "planned"
+	ActionApproval = "awaiting-approval"
+	ActionAborted  = "aborted"
+	ActionPulled   = "pulled"
+)
+
+// actions planned for a container in a dry-run
+const (
+	PlanUpdate   = "would-update"
+	PlanUpToDate = "up-to-date"
+)
+
+// restartedContainer is a container which was re-created by a webhook
+type restartedContainer struct {
+	types.Container
+	Resources *appliedResources `json:"resources,omitempty"`
+	// Restarting contains the handling if the container was caught in a restart loop
+	Restarting string `json:"restarting,omitempty"`
+	// NotStarted is true if the container was re-created but not started
+	NotStarted bool `json:"not_started,omitempty"`
+	// Signature is "verified" if the image signature was verified with cosign
+	Signature string `json:"signature,omitempty"`
+	// Warnings contains changes of the image which may break the container
+	Warnings []string `json:"warnings,omitempty"`
+	// RolledBackTo is the image ID the container was rolled back to
+	RolledBackTo string `json:"rolled_back_to,omitempty"`
+	// Strategy is recreate or zero-downtime if the new container was started before the old one was stopped
+	Strategy string `json:"strategy"`
+
+	// NewID and NewImageID are the IDs of the re-created container and its image
+	NewID      string `json:"new_id"`
+	NewImageID string `json:"new_image_id"`
+	// PreviousStartedAt and StartedAt are the start times of the old and the re-created container
+	PreviousStartedAt string `json:"previous_started_at"`
+	StartedAt         string `json:"started_at,omitempty"`
+}
+
+// rejectedContainer is a container which was not updated because its image was refused by a policy
+type rejectedContainer struct {
+	ID     string `json:"id"`
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+// failedContainer is a container which couldn't be updated or a re-created container which didn't work as expected
+type failedContainer struct {
+	ID     string `json:"id"`
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+
+	// Restore is the outcome of restoring the previous container if the new one didn't start
+	Restore      string `json:"restore,omitempty"`
+	RestoreError string `json:"restore_error,omitempty"`
+	// RestoredID is the ID of the container running the previous image again
+	RestoredID string `json:"restored_id,omitempty"`
+}
+
+// skippedContainer is a container which didn't need to be updated
+type skippedContainer struct {
+	ID     string `json:"id"`
+	Image  string `json:"image"`
+	Reason string `json:"reason"`
+}
+
+// plannedContainer is a container matched by a dry-run
+type plannedContainer struct {
+	ID     string   `json:"id"`
+	Names  []string `json:"names"`
+	Image  string   `json:"image"`
+	Action string   `json:"action"`
+}
+
+// pulledImage is an image pulled by a webhook in pull-only mode
+type pulledImage struct {
+	Image string `json:"image"`
+	// Changed is true if a matched container doesn't run the pulled image
+	Changed bool `json:"changed"`
+}
+
+// interruption describes why and where an update was interrupted
+type interruption struct {
+	Reason string `json:"reason"`
+	Phase  string `json:"phase"`
+	Error  string `json:"error"`
+}
+
+// containerOutcome is the action taken for a matched container
+type containerOutcome struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// NewID is the ID of the re-created container
+	NewID string `json:"new_id,omitempty"`
+}
+
+// UpdateResult is the response of a webhook
+type UpdateResult struct {
+	// RequestID is logged with every line of the update, see HeaderRequestID
+	RequestID string `json:"request_id"`
+	// Containers contains every matched container with the action taken
+	Containers []containerOutcome `json:"containers"`
+
+	Restarted []restartedContainer `json:"restarted"`
+	Rejected  []rejectedContainer  `json:"rejected,omitempty"`
+	Skipped   []skippedContainer   `json:"skipped,omitempty"`
+	Failed    []failedContainer    `json:"failed,omitempty"`
+	// Cascaded contains the containers restarted because of the triggers label of an updated container
+	Cascaded []cascadedContainer `json:"cascaded,omitempty"`
+	// Approval is set if containers require an approval before being updated
+	Approval *pendingApproval `json:"approval,omitempty"`
+	// Planned contains the matched containers of a dry-run
+	Planned []plannedContainer `json:"planned,omitempty"`
+	// Pulled contains the images pulled in pull-only mode
+	Pulled []pulledImage `json:"pulled,omitempty"`
+	// SpaceReclaimed is the number of bytes freed by pruning dangling images after the update
+	SpaceReclaimed uint64 `json:"space_reclaimed,omitempty"`
+	// Interrupted is set if the update exceeded its maximum duration or was cancelled by the shutdown
+	Interrupted *interruption `json:"interrupted,omitempty"`
+}
+
+// outcome records the action taken for a matched container
+func (r *UpdateResult) outcome(cont types.Container, action, reason string, err error) {
+	o := containerOutcome{
+		ID:     cont.ID,
+		Name:   containerKey(cont.Names, cont.ID),
+		Image:  cont.Image,
+		Action: action,
+		Reason: reason,
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	r.Containers = append(r.Containers, o)
+}
+
+// reject adds a container whose image was refused by a policy
+func (r *UpdateResult) reject(cont types.Container, reason string, err error) {
+	r.Rejected = append(r.Rejected, rejectedContainer{
+		ID:     cont.ID,
+		Image:  cont.Image,
+		Reason: reason,
+		Error:  err.Error(),
+	})
+	r.outcome(cont, ActionRejected, reason, err)
+}
+
+// skip adds a container which didn't need to be updated
+func (r *UpdateResult) skip(cont types.Container, reason string) {
+	r.Skipped = append(r.Skipped, skippedContainer{
+		ID:     cont.ID,
+		Image:  cont.Image,
+		Reason: reason,
+	})
+	r.outcome(cont, ActionSkipped, reason, nil)
+}
+
+// fail adds a container which couldn't be updated
+func (r *UpdateResult) fail(cont types.Container, reason string, err error) {
+	r.Failed = append(r.Failed, failedContainer{
+		ID:     cont.ID,
+		Image:  cont.Image,
+		Reason: reason,
+		Error:  err.Error(),
+	})
+	r.outcome(cont, ActionFailed, reason, err)
+}
+
+// restart adds a re-created container
+func (r *UpdateResult) restart(c restartedContainer) {
+	r.Restarted = append(r.Restarted, c)
+	r.outcome(c.Container, ActionUpdated, "", nil)
+	r.Containers[len(r.Containers)-1].NewID = c.NewID
+}
+
+// unstable adds re-created containers which failed after the update
+func (r *UpdateResult) unstable(failed []failedContainer) {
+	r.Failed = append(r.Failed, failed...)
+	for _, f := range failed {
+		for i := range r.Containers {
+			if r.Containers[i].NewID == f.ID {
+				r.Containers[i].Action = ActionFailed
+				r.Containers[i].Reason = f.Reason
+				r.Containers[i].Error = f.Error
+			}
+		}
+	}
+}
+
+// abort adds the matched containers without an outcome, e.g. after a timeout
+func (r *UpdateResult) abort(matched []types.Container) {
+	seen := make(map[string]bool, len(r.Containers))
+	for _, o := range r.Containers {
+		seen[o.ID] = true
+	}
+	for _, cont := range matched {
+		if !seen[cont.ID] {
+			r.outcome(cont, ActionAborted, "", nil)
+		}
+	}
+}
+
+// plan adds a container matched by a dry-run
+func (r *UpdateResult) plan(cont types.Container, action string) {
+	r.Planned = append(r.Planned, plannedContainer{
+		ID:     cont.ID,
+		Names:  cont.Names,
+		Image:  cont.Image,
+		Action: action,
+	})
+	r.outcome(cont, ActionPlanned, action, nil)
+}
+
+// pull adds a container whose image was pulled in pull-only mode
+func (r *UpdateResult) pull(cont types.Container, changed bool) {
+	r.outcome(cont, ActionPulled, "", nil)
+	r.addPulled(pulledImage{Image: cont.Image, Changed: changed})
+}
+
+// addPulled adds img once, it's changed if any of the containers running it changed
+func (r *UpdateResult) addPulled(img pulledImage) {
+	for i := range r.Pulled {
+		if r.Pulled[i].Image == img.Image {
+			r.Pulled[i].Changed = r.Pulled[i].Changed || img.Changed
+			return
+		}
+	}
+	r.Pulled = append(r.Pulled, img)
+}
+
+// merge adds the outcome of a single container, see attributes.concurrency
+func (r *UpdateResult) merge(part *UpdateResult) {
+	r.Containers = append(r.Containers, part.Containers...)
+	r.Restarted = append(r.Restarted, part.Restarted...)
+	r.Rejected = append(r.Rejected, part.Rejected...)
+	r.Skipped = append(r.Skipped, part.Skipped...)
+	r.Failed = append(r.Failed, part.Failed...)
+	r.Planned = append(r.Planned, part.Planned...)
+	for _, img := range part.Pulled {
+		r.addPulled(img)
+	}
+}
+
+// status returns the HTTP status of the result, 207 if some containers failed and others were updated,
+// 504 or 503 if the update was interrupted. Containers which didn't stabilize fail the whole update
+func (r *UpdateResult) status() int {
+	if r.Interrupted != nil {
+		if r.Interrupted.Reason == InterruptTimeout {
+			return fiber.StatusGatewayTimeout
+		}
+		return fiber.StatusServiceUnavailable
+	}
+	if len(r.Failed) > 0 {
+		for _, c := range r.Failed {
+			if c.Reason == FailUnstable {
+				return fiber.StatusInternalServerError
+			}
+		}
+		for _, c := range r.Containers {
+			if c.Action == ActionUpdated {
+				return fiber.StatusMultiStatus
+			}
+		}
+		return fiber.StatusInternalServerError
+	}
+	if len(r.Rejected) > 0 {
+		return fiber.StatusUnprocessableEntity
+	}
+	if r.Approval != nil {
+		return fiber.StatusAccepted
+	}
+	return fiber.StatusOK
+}
+
+// render writes the result as JSON or, if requested by ?format=text or the Accept header, as plain text
+func (r *UpdateResult) render(ctx *fiber.Ctx) error {
+	ctx.Status(r.status())
+	format := strings.ToLower(ctx.Query("format"))
+	if format == "" && ctx.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextPlain) == fiber.MIMETextPlain {
+		format = "text"
+	}
+	if format != "text" {
+		return ctx.JSON(r)
+	}
+	ctx.Type("txt")
+	return ctx.SendString(r.text())
+}
+
+// text returns a human-readable summary of the result
+func (r *UpdateResult) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "updated: %d\n", len(r.Restarted))
+	for _, c := range r.Restarted {
+		line := fmt.Sprintf("  %s %s -> %s", containerKey(c.Names, c.ID), c.Image, trimID(c.NewImageID))
+		if c.NotStarted {
+			line += " (not started)"
+		}
+		if c.RolledBackTo != "" {
+			line += " (rolled back)"
+		}
+		b.WriteString(line + "\n")
+		for _, w := range c.Warnings {
+			fmt.Fprintf(&b, "    warning: %s\n", w)
+		}
+	}
+	if len(r.Planned) > 0 {
+		fmt.Fprintf(&b, "planned: %d\n", len(r.Planned))
+		for _, c := range r.Planned {
+			fmt.Fprintf(&b, "  %s %s (%s)\n", containerKey(c.Names, c.ID), c.Image, c.Action)
+		}
+	}
+	if len(r.Skipped) > 0 {
+		fmt.Fprintf(&b, "skipped: %d\n", len(r.Skipped))
+		for _, c := range r.Skipped {
+			fmt.Fprintf(&b, "  %s %s (%s)\n", trimID(c.ID), c.Image, c.Reason)
+		}
+	}
+	if len(r.Rejected) > 0 {
+		fmt.Fprintf(&b, "rejected: %d\n", len(r.Rejected))
+		for _, c := range r.Rejected {
+			fmt.Fprintf(&b, "  %s %s (%s: %s)\n", trimID(c.ID), c.Image, c.Reason, c.Error)
+		}
+	}
+	if len(r.Failed) > 0 {
+		fmt.Fprintf(&b, "failed: %d\n", len(r.Failed))
+		for _, c := range r.Failed {
+			fmt.Fprintf(&b, "  %s %s (%s: %s)\n", trimID(c.ID), c.Image, c.Reason, c.Error)
+			switch {
+			case c.RestoreError != "":
+				fmt.Fprintf(&b, "    %s: %s\n", c.Restore, c.RestoreError)
+			case c.Restore != "":
+				fmt.Fprintf(&b, "    %s to %s\n", c.Restore, trimID(c.RestoredID))
+			}
+		}
+	}
+	if len(r.Cascaded) > 0 {
+		fmt.Fprintf(&b, "cascaded: %d\n", len(r.Cascaded))
+		for _, c := range r.Cascaded {
+			line := fmt.Sprintf("  %s (triggered by %s)", c.Name, c.TriggeredBy)
+			if c.Error != "" {
+				line += ": " + c.Error
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	var aborted []string
+	for _, c := range r.Containers {
+		if c.Action == ActionAborted {
+			aborted = append(aborted, c.Name)
+		}
+	}
+	if len(aborted) > 0 {
+		fmt.Fprintf(&b, "aborted: %d\n  %s\n", len(aborted), strings.Join(aborted, ", "))
+	}
+	if r.Interrupted != nil {
+		fmt.Fprintf(&b, "interrupted: %s\n", r.Interrupted.Error)
+	}
+	if r.Approval != nil {
+		fmt.Fprintf(&b, "awaiting approval: %s (%d containers)\n", r.Approval.ID, len(r.Approval.Containers))
+	}
+	return b.String()
+}
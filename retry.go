@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// retryPolicy describes how often and how long to wait between retries of outgoing calls
+type retryPolicy struct {
+	attempts int           // total attempts, including the first one
+	base     time.Duration // delay before the first retry
+	max      time.Duration // maximum delay between retries
+}
+
+// notifyRetry is used by all outgoing notifications
+var notifyRetry = retryPolicy{
+	attempts: 3,
+	base:     500 * time.Millisecond,
+	max:      30 * time.Second,
+}
+
+var (
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMu   sync.Mutex
+)
+
+// delay returns the exponential backoff for the given retry with full jitter
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.base << retry
+	if d <= 0 || d > p.max {
+		d = p.max
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(d)) + 1)
+}
+
+// do calls fn until it succeeds, the attempts are exhausted or dctx is done
+func (p retryPolicy) do(dctx context.Context, fn func(dctx context.Context) error) (err error) {
+	for attempt := 0; attempt < p.attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-dctx.Done():
+				return dctx.Err()
+			case <-time.After(p.delay(attempt - 1)):
+			}
+		}
+		if err = fn(dctx); err == nil {
+			return nil
+		}
+	}
+	return
+}
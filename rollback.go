@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// localRollback is the fiber local which is true if the matched containers should be rolled back
+const localRollback = "yadwh-rollback"
+
+// previousImage is the image a container ran before it was updated
+type previousImage struct {
+	ImageID string    `json:"image_id"`
+	At      time.Time `json:"at"`
+}
+
+var (
+	previousImages   = make(map[string]previousImage) // container name -> previous image
+	previousImagesMu sync.Mutex
+
+	// rollbackRetention is the time a previous image is kept for rollbacks, 0 = until the next update
+	rollbackRetention time.Duration
+)
+
+// containerKey returns the name of a container, which unlike the ID survives re-creation
+func containerKey(names []string, id string) string {
+	if name := canonicalName(names); name != "" {
+		return name
+	}
+	return id
+}
+
+// recordPrevious remembers the image a container ran before it was updated
+func recordPrevious(key, imageID string) {
+	previousImagesMu.Lock()
+	previousImages[key] = previousImage{ImageID: imageID, At: time.Now()}
+	previousImagesMu.Unlock()
+}
+
+// previousFor returns the previous image of a container if it's not expired
+func previousFor(key string) (prev previousImage, ok bool) {
+	previousImagesMu.Lock()
+	defer previousImagesMu.Unlock()
+	if prev, ok = previousImages[key]; !ok {
+		return
+	}
+	if rollbackRetention > 0 && time.Since(prev.At) > rollbackRetention {
+		delete(previousImages, key)
+		return prev, false
+	}
+	return
+}
+
+// forgetPrevious removes the previous image of a container after it was rolled back
+func forgetPrevious(key string) {
+	previousImagesMu.Lock()
+	delete(previousImages, key)
+	previousImagesMu.Unlock()
+}
+
+// sweepPrevious purges previous images older than rollbackRetention
+func sweepPrevious(now time.Time) int {
+	previousImagesMu.Lock()
+	defer previousImagesMu.Unlock()
+	if rollbackRetention > 0 {
+		for key, prev := range previousImages {
+			if now.Sub(prev.At) > rollbackRetention {
+				delete(previousImages, key)
+			}
+		}
+	}
+	return len(previousImages)
+}
+
+// rollback re-creates all matched containers with a recorded previous image from that image
+func rollback(name, secret string, ctx *fiber.Ctx) error {
+	ctx.Locals(localRollback, true)
+	return process(strings.TrimSpace(name), secret, ctx)
+}
+
+// outcomes of restoring a container whose re-created container failed to start
+const (
+	RestoreRolledBack = "rolled-back"
+	RestoreFailed     = "rollback-failed"
+)
+
+// containerSnapshot is the config of a container before it was changed for an update
+type containerSnapshot struct {
+	name       string
+	imageID    string
+	config     container.Config
+	hostConfig container.HostConfig
+	networking *network.NetworkingConfig
+}
+
+// snapshotContainer copies the config of an inspected container, the maps changed by an update are cloned
+func snapshotContainer(name string, inspect *types.ContainerJSON) *containerSnapshot {
+	s := &containerSnapshot{
+		name:       name,
+		imageID:    inspect.Image,
+		config:     *inspect.Config,
+		hostConfig: *inspect.HostConfig,
+		networking: networkingConfig(inspect),
+	}
+	if inspect.Config.Labels != nil {
+		s.config.Labels = make(map[string]string, len(inspect.Config.Labels))
+		for k, v := range inspect.Config.Labels {
+			s.config.Labels[k] = v
+		}
+	}
+	if inspect.Config.ExposedPorts != nil {
+		s.config.ExposedPorts = make(map[nat.Port]struct{}, len(inspect.Config.ExposedPorts))
+		for k, v := range inspect.Config.ExposedPorts {
+			s.config.ExposedPorts[k] = v
+		}
+	}
+	if inspect.HostConfig.PortBindings != nil {
+		s.hostConfig.PortBindings = make(nat.PortMap, len(inspect.HostConfig.PortBindings))
+		for k, v := range inspect.HostConfig.PortBindings {
+			s.hostConfig.PortBindings[k] = v
+		}
+	}
+	return s
+}
+
+// restoreFailed restores the snapshot of cont in place of the new container id, which failed with reason,
+// and returns the failed container with the outcome of the restore
+func (s *containerSnapshot) restoreFailed(dctx context.Context, cont types.Container, id, reason string, cause error) failedContainer {
+	failed := failedContainer{
+		ID:     id,
+		Image:  cont.Image,
+		Reason: reason,
+		Error:  cause.Error(),
+	}
+	restoredID, err := s.restore(dctx, id)
+	if err != nil {
+		log.WithError(err).Errorf("Update of container %s failed (%v), rollback also failed", trimID(cont.ID), cause)
+		failed.Restore = RestoreFailed
+		failed.RestoreError = err.Error()
+	} else {
+		log.WithError(cause).Warnf("Update of container %s failed, rolled back to image %s",
+			trimID(cont.ID), trimID(s.imageID))
+		failed.Restore = RestoreRolledBack
+	}
+	failed.RestoredID = restoredID
+	return failed
+}
+
+// restore replaces the container failedID, which could not be started, with a container of the snapshot.
+// The image reference is pointed back to the previous image, so the container runs the old version again
+func (s *containerSnapshot) restore(dctx context.Context, failedID string) (id string, err error) {
+	if err = dc.ContainerRemove(dctx, failedID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return
+	}
+	if err = dc.ImageTag(dctx, s.imageID, s.config.Image); err != nil {
+		return
+	}
+	config, hostConfig := s.config, s.hostConfig
+	var created container.ContainerCreateCreatedBody
+	if created, err = dc.ContainerCreate(dctx, &config, &hostConfig, s.networking, nil, s.name); err != nil {
+		return
+	}
+	if err = dc.ContainerStart(dctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return created.ID, err
+	}
+	log.Infof("Restored container %s with image %s", trimID(created.ID), trimID(s.imageID))
+	return created.ID, nil
+}
+
+// atomicRollbackTimeout bounds restoring all containers of an atomic update, which may run after the update timed out
+const atomicRollbackTimeout = 2 * time.Minute
+
+// rollBackAll restores the snapshots of all re-created containers of an atomic update which failed because of cause.
+// The containers are moved from the restarted to the failed containers of the result
+func (r *UpdateResult) rollBackAll(snapshots map[string]*containerSnapshot, cause string) {
+	dctx, cancel := context.WithTimeout(context.Background(), atomicRollbackTimeout)
+	defer cancel()
+	for _, c := range r.Restarted {
+		s, ok := snapshots[c.NewID]
+		if !ok {
+			continue
+		}
+		// containers which already failed (e.g. unstable ones) keep their reason
+		i := -1
+		for j := range r.Failed {
+			if r.Failed[j].ID == c.NewID {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			r.Failed = append(r.Failed, failedContainer{ID: c.NewID, Image: c.Image, Reason: FailAtomic, Error: cause})
+			i = len(r.Failed) - 1
+		}
+		restoredID, err := s.restore(dctx, c.NewID)
+		if err != nil {
+			log.WithError(err).Errorf("Cannot restore container %s of the atomic update", trimID(c.ID))
+			r.Failed[i].Restore, r.Failed[i].RestoreError = RestoreFailed, err.Error()
+		} else {
+			log.Infof("Rolled back container %s of the atomic update to image %s", trimID(c.ID), trimID(s.imageID))
+			r.Failed[i].Restore = RestoreRolledBack
+			forgetPrevious(containerKey(c.Names, c.ID))
+		}
+		r.Failed[i].RestoredID = restoredID
+		for j := range r.Containers {
+			if r.Containers[j].NewID == c.NewID && r.Containers[j].Action == ActionUpdated {
+				r.Containers[j].Action = ActionFailed
+				r.Containers[j].Reason = FailAtomic
+				r.Containers[j].Error = cause
+			}
+		}
+	}
+	r.Restarted = []restartedContainer{}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// signature modes of a webhook
+const (
+	SignatureSHA256 = "sha256" // GitHub-style X-Hub-Signature-256
+)
+
+// HeaderSignature256 contains the HMAC-SHA256 of the request body, prefixed by sha256=
+const HeaderSignature256 = "X-Hub-Signature-256"
+
+// ErrSignatureInvalid is returned if the signature of a request is missing or invalid
+var ErrSignatureInvalid = fiber.NewError(401, "signature mismatch")
+
+// signBody returns the GitHub-style signature of body with secret
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return SignatureSHA256 + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorizeSignature checks the signature of the request body against the secret of the webhook
+func authorizeSignature(a *attributes, ctx *fiber.Ctx) (*attributes, error) {
+	given := strings.TrimSpace(ctx.Get(HeaderSignature256))
+	if given == "" {
+		return nil, ErrSignatureInvalid
+	}
+	if !hmac.Equal([]byte(strings.ToLower(given)), []byte(signBody(a.secret, ctx.Body()))) {
+		return nil, ErrSignatureInvalid
+	}
+	return a, nil
+}
+
+// authorizeRequest returns the attributes of the webhook name if the request is authorized
+// by its signature, secret or client certificate.
+// Webhooks with a signature mode only accept signed requests
+func authorizeRequest(name, secret string, ctx *fiber.Ctx) (*attributes, error) {
+	a, ok := attrs[name]
+	// approved updates were authorized by the admin token
+	if ok && a != nil && ctx.Locals(localApproved) != nil {
+		return a, nil
+	}
+	if ok && a != nil && a.signature != "" {
+		return authorizeSignature(a, ctx)
+	}
+	if secret == "" {
+		return authorizeCert(name, ctx)
+	}
+	return authorize(name, secret)
+}
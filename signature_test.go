@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// example delivery of the GitHub webhook documentation
+const (
+	githubSecret    = "It's a Secret to Everybody"
+	githubPayload   = "Hello, World!"
+	githubSignature = "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+)
+
+func TestSignBody(t *testing.T) {
+	if got := signBody(githubSecret, []byte(githubPayload)); got != githubSignature {
+		t.Errorf("signBody() = %s, want %s", got, githubSignature)
+	}
+}
+
+func TestAuthorizeSignature(t *testing.T) {
+	a := &attributes{secret: githubSecret, signature: SignatureSHA256}
+	app := fiber.New()
+	app.Post("/", func(ctx *fiber.Ctx) error {
+		if _, err := authorizeSignature(a, ctx); err != nil {
+			return err
+		}
+		return ctx.SendStatus(fiber.StatusOK)
+	})
+
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		status    int
+	}{
+		{"valid", githubPayload, githubSignature, fiber.StatusOK},
+		{"uppercase hex", githubPayload, "sha256=" + strings.ToUpper(strings.TrimPrefix(githubSignature, "sha256=")), fiber.StatusOK},
+		{"tampered body", githubPayload + "!", githubSignature, fiber.StatusUnauthorized},
+		{"missing prefix", githubPayload, strings.TrimPrefix(githubSignature, "sha256="), fiber.StatusUnauthorized},
+		{"missing header", githubPayload, "", fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set(HeaderSignature256, tt.signature)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
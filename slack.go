@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"github.com/apex/log"
+	"strings"
+)
+
+// slackMessage is the payload of a Slack incoming webhook
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// slackText returns the summary of an update posted to Slack
+func slackText(name string, restarted []restartedContainer) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s* updated %d container(s)", name, len(restarted))
+	for _, r := range restarted {
+		fmt.Fprintf(&b, "\n• `%s` %s: %s → %s", containerKey(r.Names, r.ID), r.Image, trimID(r.ImageID), trimID(r.NewImageID))
+		if r.RolledBackTo != "" {
+			b.WriteString(" (rollback)")
+		}
+	}
+	return b.String()
+}
+
+// notifySlack posts a summary of the updated containers to the Slack webhook of a in the background,
+// so a slow Slack doesn't delay the response
+func (a *attributes) notifySlack(name string, result *UpdateResult) {
+	if a.slackURL == "" || len(result.Restarted) == 0 {
+		return
+	}
+	msg := slackMessage{Text: slackText(name, result.Restarted)}
+	go func() {
+		if err := postJSON(shutdownCtx, a.slackURL, msg); err != nil {
+			log.WithError(err).Warnf("Cannot notify Slack about update of %s", name)
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnix listens on the Unix socket at path with the file mode perm.
+// A socket left over by a previous run is replaced, other files are not
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is created with the umask, restrict it before serving
+	if err = os.Chmod(path, perm); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"time"
+)
+
+// progressEvent is reported whenever an update enters a new phase
+type progressEvent struct {
+	Webhook string    `json:"webhook"`
+	Phase   string    `json:"phase"`
+	Time    time.Time `json:"time"`
+}
+
+// report calls the progress callback of the update, if any
+func (u *updateRun) report(phase string) {
+	if u.progress != nil {
+		u.progress(progressEvent{Webhook: u.name, Phase: phase, Time: time.Now()})
+	}
+}
+
+// writeSSE writes a single server-sent event.
+// Errors are ignored, the update continues if the client went away
+func writeSSE(w *bufio.Writer, event string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	_ = w.Flush()
+}
+
+// streamBuffer is the number of progress events buffered for a slow client, later events are dropped
+const streamBuffer = 64
+
+// streamResult is the outcome of a streamed update
+type streamResult struct {
+	result *UpdateResult
+	err    error
+}
+
+// stream runs the update while streaming its progress as server-sent events.
+// The last event is either done with the UpdateResult or error.
+// The update runs in the background and releases the update lock of the webhook and untracks itself once it finished,
+// even if the client went away before the stream was written
+func (u *updateRun) stream(ctx *fiber.Ctx) error {
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+
+	// the name may point into the request, which is reused after the handler returned
+	u.name = utils.CopyString(u.name)
+	progress := make(chan progressEvent, streamBuffer)
+	finished := make(chan streamResult, 1)
+	u.progress = func(ev progressEvent) {
+		select {
+		case progress <- ev:
+		default:
+		}
+	}
+	go func() {
+		defer u.untrack()
+		defer u.expected.lock.unlock()
+		result, err := u.run()
+		finished <- streamResult{result: result, err: err}
+	}()
+
+	conn := ctx.Context().Conn()
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// the stream lasts as long as the update, not only the write timeout
+		_ = conn.SetWriteDeadline(time.Time{})
+		for {
+			select {
+			case ev := <-progress:
+				writeSSE(w, "progress", ev)
+			case f := <-finished:
+				for len(progress) > 0 {
+					writeSSE(w, "progress", <-progress)
+				}
+				if f.err != nil {
+					writeSSE(w, "error", fiber.Map{"error": f.err.Error()})
+					return
+				}
+				writeSSE(w, "done", fiber.Map{"status": f.result.status(), "result": f.result})
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamedWebhook registers the webhook APP updating a single container of the fake daemon
+func streamedWebhook(t *testing.T) (*attributes, *updatingDaemon) {
+	daemon := &updatingDaemon{current: types.Container{
+		ID:      "app0",
+		Names:   []string{"/app"},
+		Image:   "app:latest",
+		ImageID: "sha256:old",
+		Labels:  map[string]string{LabelKey: "APP"},
+	}}
+	fakeDocker(t, daemon.ServeHTTP)
+	a := &attributes{
+		secret:       "secret",
+		mode:         ModeUpdate,
+		conflictMode: ConflictQueue,
+		stopTimeout:  -1,
+		concurrency:  1,
+		lock:         newUpdateLock(),
+	}
+	attrs["APP"] = a
+	t.Cleanup(func() { delete(attrs, "APP") })
+	return a, daemon
+}
+
+func TestStream(t *testing.T) {
+	a, _ := streamedWebhook(t)
+	app := fiber.New()
+	app.All("/:name/:secret", func(ctx *fiber.Ctx) error {
+		return process(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/APP/secret?stream=sse", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "event: progress") || !strings.Contains(string(body), "event: done") {
+		t.Errorf("expected progress and done events, got %s", body)
+	}
+	if !a.lock.tryLock() {
+		t.Fatal("expected the update lock to be released after the stream")
+	}
+	a.lock.unlock()
+}
+
+// TestStreamClientGone checks that the update lock is released
+// if the client went away before the stream was written
+func TestStreamClientGone(t *testing.T) {
+	a, daemon := streamedWebhook(t)
+	app := fiber.New()
+	app.All("/:name/:secret", func(ctx *fiber.Ctx) error {
+		return process(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write([]byte("POST /APP/secret?stream=sse HTTP/1.1\r\nHost: yadwh\r\nContent-Length: 0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	// the update finishes without the client
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		daemon.mu.Lock()
+		created := daemon.created
+		daemon.mu.Unlock()
+		if created == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the container to be re-created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = a.lock.lock(lctx); err != nil {
+		t.Fatalf("expected the update lock to be released: %v", err)
+	}
+	a.lock.unlock()
+}
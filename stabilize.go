@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"time"
+)
+
+// stabilize waits for the stabilization period and returns the started containers
+// which stopped, restarted or became unhealthy in the meantime
+func (a *attributes) stabilize(dctx context.Context, restarted []restartedContainer) (failed []failedContainer) {
+	// restart counts before the window, a crash is hidden by restart policies otherwise
+	restarts := make(map[string]int)
+	for _, r := range restarted {
+		if r.NotStarted || r.NewID == "" {
+			continue
+		}
+		inspect, err := dc.ContainerInspect(dctx, r.NewID)
+		if err != nil {
+			failed = append(failed, failedContainer{ID: r.NewID, Image: r.Image, Reason: FailUnstable, Error: err.Error()})
+			continue
+		}
+		restarts[r.NewID] = inspect.RestartCount
+	}
+	if len(restarts) == 0 {
+		return
+	}
+
+	log.Infof("Waiting %s for %d containers to stabilize", a.stabilization, len(restarts))
+	select {
+	case <-dctx.Done():
+		return
+	case <-time.After(a.stabilization):
+	}
+
+	for _, r := range restarted {
+		count, ok := restarts[r.NewID]
+		if !ok {
+			continue
+		}
+		inspect, err := dc.ContainerInspect(dctx, r.NewID)
+		if err == nil {
+			switch {
+			case inspect.State == nil || !inspect.State.Running:
+				err = fmt.Errorf("container is not running")
+			case inspect.RestartCount > count:
+				err = fmt.Errorf("container restarted %d times", inspect.RestartCount-count)
+			case inspect.State.Health != nil && inspect.State.Health.Status == types.Unhealthy:
+				err = errUnhealthy
+			}
+		}
+		if err != nil {
+			log.WithError(err).Warnf("Container %s did not stabilize", trimID(r.NewID))
+			failed = append(failed, failedContainer{ID: r.NewID, Image: r.Image, Reason: FailUnstable, Error: err.Error()})
+		}
+	}
+	return
+}
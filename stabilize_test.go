@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stabilizedContainers fakes the Docker API for containers which are running when they were re-created.
+// The containers of crashed are not running anymore once they were inspected again.
+// Containers can be restored, restored contains the names of the created containers
+func stabilizedContainers(t *testing.T, crashed map[string]bool) (restored func() []string) {
+	var (
+		mu       sync.Mutex
+		inspects = make(map[string]int)
+		created  []string
+	)
+	fakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path := r.URL.Path
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+			id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+			inspects[id]++
+			running := inspects[id] == 1 || !crashed[id]
+			writeJSON(w, http.StatusOK, types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    id,
+				State: &types.ContainerState{Running: running},
+			}})
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/containers/"):
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/tag"):
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && path == "/containers/create":
+			created = append(created, r.URL.Query().Get("name"))
+			writeJSON(w, http.StatusCreated, container.ContainerCreateCreatedBody{ID: "restored-" + r.URL.Query().Get("name")[1:]})
+		case r.Method == http.MethodPost && strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/start"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			notFound(w)
+		}
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), created...)
+	}
+}
+
+// stabilizeResult returns the result of an update which re-created the containers names
+func stabilizeResult(names ...string) (*UpdateResult, map[string]*containerSnapshot) {
+	result := &UpdateResult{Restarted: []restartedContainer{}, Containers: []containerOutcome{}}
+	snapshots := make(map[string]*containerSnapshot)
+	for _, name := range names {
+		cont := types.Container{ID: "old-" + name, Names: []string{"/" + name}, Image: name + ":latest"}
+		result.restart(restartedContainer{Container: cont, NewID: "new-" + name})
+		snapshots["new-"+name] = snapshotContainer("/"+name, &types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         cont.ID,
+				Image:      "sha256:old-" + name,
+				HostConfig: &container.HostConfig{NetworkMode: "host"},
+			},
+			Config: &container.Config{Image: cont.Image},
+		})
+	}
+	return result, snapshots
+}
+
+func TestStabilize(t *testing.T) {
+	a := &attributes{stabilization: 10 * time.Millisecond}
+	tests := []struct {
+		name    string
+		crashed map[string]bool
+		status  int
+	}{
+		{"all stable", nil, fiber.StatusOK},
+		{"one crashed", map[string]bool{"new-api": true}, fiber.StatusInternalServerError},
+		{"all crashed", map[string]bool{"new-api": true, "new-db": true}, fiber.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stabilizedContainers(t, tt.crashed)
+			result, _ := stabilizeResult("db", "api")
+			result.unstable(a.stabilize(shutdownCtx, result.Restarted))
+			if len(result.Failed) != len(tt.crashed) {
+				t.Errorf("expected %d unstable containers, got %+v", len(tt.crashed), result.Failed)
+			}
+			for _, f := range result.Failed {
+				if !tt.crashed[f.ID] || f.Reason != FailUnstable {
+					t.Errorf("unexpected failed container %+v", f)
+				}
+			}
+			// an unstable container fails the update, even if other containers were updated
+			if got := result.status(); got != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, got)
+			}
+		})
+	}
+}
+
+func TestStabilizeAtomicRollback(t *testing.T) {
+	a := &attributes{stabilization: 10 * time.Millisecond, atomic: true}
+	restored := stabilizedContainers(t, map[string]bool{"new-api": true})
+	result, snapshots := stabilizeResult("db", "api")
+
+	result.unstable(a.stabilize(shutdownCtx, result.Restarted))
+	result.rollBackAll(snapshots, "another container of the update failed")
+
+	if got := restored(); len(got) != 2 || got[0] != "/db" || got[1] != "/api" {
+		t.Errorf("expected /db and /api to be restored, got %v", got)
+	}
+	if len(result.Restarted) != 0 {
+		t.Errorf("expected no updated containers, got %+v", result.Restarted)
+	}
+	reasons := make(map[string]string)
+	for _, f := range result.Failed {
+		if f.Restore != RestoreRolledBack || f.RestoredID != "restored-"+strings.TrimPrefix(f.ID, "new-") {
+			t.Errorf("expected %s to be rolled back, got %+v", f.ID, f)
+		}
+		reasons[f.ID] = f.Reason
+	}
+	if reasons["new-api"] != FailUnstable || reasons["new-db"] != FailAtomic {
+		t.Errorf("expected api to be unstable and db to be rolled back, got %v", reasons)
+	}
+	for _, c := range result.Containers {
+		if c.Action != ActionFailed {
+			t.Errorf("expected %s to be failed, got %s", c.Name, c.Action)
+		}
+	}
+	if got := result.status(); got != fiber.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/apex/log"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the interval of the background sweeper purging expired records
+const sweepInterval = time.Minute
+
+// sweep purges expired records and returns the amount of remaining records
+type sweep func(now time.Time) (remaining int)
+
+var (
+	sweeps   = make(map[string]sweep)
+	counts   = make(map[string]int) // remaining records after the last sweep
+	sweepsMu sync.Mutex
+)
+
+// registerSweep adds a sweep for the records called name
+func registerSweep(name string, fn sweep) {
+	sweepsMu.Lock()
+	sweeps[name] = fn
+	sweepsMu.Unlock()
+}
+
+// runSweeps runs all sweeps once
+func runSweeps() {
+	sweepsMu.Lock()
+	defer sweepsMu.Unlock()
+	now := time.Now()
+	for name, fn := range sweeps {
+		remaining := fn(now)
+		if purged := counts[name] - remaining; purged > 0 {
+			log.Debugf("Purged %d expired %s records", purged, name)
+		}
+		counts[name] = remaining
+		metricRecords.WithLabelValues(name).Set(float64(remaining))
+	}
+}
+
+// recordCounts returns the amount of records after the last sweep
+func recordCounts() map[string]int {
+	sweepsMu.Lock()
+	defer sweepsMu.Unlock()
+	res := make(map[string]int, len(counts))
+	for k, v := range counts {
+		res[k] = v
+	}
+	return res
+}
+
+// startSweeper purges expired records every sweepInterval until shutdown
+func startSweeper() {
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shutdownCtx.Done():
+				return
+			case <-ticker.C:
+				runSweeps()
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// conflict modes if a webhook is triggered while an update is running
+const (
+	ConflictQueue  = "queue"  // wait for the running update
+	ConflictReject = "reject" // answer with 409
+)
+
+// updateLock serializes the updates of a webhook
+type updateLock struct {
+	sem   chan struct{}
+	mu    sync.Mutex
+	since time.Time // start of the running update
+}
+
+func newUpdateLock() *updateLock {
+	return &updateLock{sem: make(chan struct{}, 1)}
+}
+
+func (l *updateLock) acquired() {
+	l.mu.Lock()
+	l.since = time.Now()
+	l.mu.Unlock()
+}
+
+// tryLock locks l if no update is running
+func (l *updateLock) tryLock() bool {
+	select {
+	case l.sem <- struct{}{}:
+		l.acquired()
+		return true
+	default:
+		return false
+	}
+}
+
+// lock waits until l is locked or dctx is done
+func (l *updateLock) lock(dctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+		l.acquired()
+		return nil
+	case <-dctx.Done():
+		return dctx.Err()
+	}
+}
+
+func (l *updateLock) unlock() {
+	l.mu.Lock()
+	l.since = time.Time{}
+	l.mu.Unlock()
+	<-l.sem
+}
+
+// runningSince returns the start of the running update or zero if no update is running
+func (l *updateLock) runningSince() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.since
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// updatingDaemon is a fake Docker daemon running a single labeled container of app:latest.
+// Re-creating the container replaces it with a container of the new image
+type updatingDaemon struct {
+	mu      sync.Mutex
+	current types.Container
+	created int
+}
+
+func (d *updatingDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && path == "/containers/json":
+		writeJSON(w, http.StatusOK, []types.Container{d.current})
+	case r.Method == http.MethodPost && path == "/images/create":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "Downloaded newer image for app:latest"})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/images/"):
+		id := "sha256:new"
+		if strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json") == "sha256:old" {
+			id = "sha256:old"
+		}
+		writeJSON(w, http.StatusOK, types.ImageInspect{ID: id, Config: &container.Config{}})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/containers/"):
+		ref := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		if ref != d.current.ID {
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         d.current.ID,
+				Name:       "/app",
+				Image:      d.current.ImageID,
+				State:      &types.ContainerState{Running: true},
+				HostConfig: &container.HostConfig{},
+			},
+			Config:          &container.Config{Image: d.current.Image, Labels: d.current.Labels},
+			NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{}},
+		})
+	case r.Method == http.MethodPost && path == "/containers/create":
+		d.created++
+		d.current.ID = fmt.Sprintf("app%d", d.created)
+		d.current.ImageID = "sha256:new"
+		writeJSON(w, http.StatusCreated, container.ContainerCreateCreatedBody{ID: d.current.ID})
+	case r.Method == http.MethodPost, r.Method == http.MethodDelete:
+		// stop, start and remove
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		notFound(w)
+	}
+}
+
+// TestProcessSerializesWebhook triggers the same webhook twice at once,
+// the second update must see the re-created container and leave it alone
+func TestProcessSerializesWebhook(t *testing.T) {
+	daemon := &updatingDaemon{current: types.Container{
+		ID:      "app0",
+		Names:   []string{"/app"},
+		Image:   "app:latest",
+		ImageID: "sha256:old",
+		Labels:  map[string]string{LabelKey: "APP"},
+	}}
+	fakeDocker(t, daemon.ServeHTTP)
+	attrs["APP"] = &attributes{
+		secret:       "secret",
+		mode:         ModeUpdate,
+		conflictMode: ConflictQueue,
+		stopTimeout:  -1,
+		concurrency:  1,
+		lock:         newUpdateLock(),
+	}
+	t.Cleanup(func() { delete(attrs, "APP") })
+
+	app := fiber.New()
+	app.All("/:name/:secret", func(ctx *fiber.Ctx) error {
+		return process(ctx.Params("name"), ctx.Params("secret"), ctx)
+	})
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 2)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/APP/secret", nil), -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses[i] = resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != fiber.StatusOK {
+			t.Errorf("request %d: expected status 200, got %d", i, status)
+		}
+	}
+	if daemon.created != 1 {
+		t.Errorf("expected the container to be re-created once, got %d", daemon.created)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runRecord is the outcome of an update of a webhook
+type runRecord struct {
+	At        time.Time `json:"at"`
+	RequestID string    `json:"request_id"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Updated   int       `json:"updated"`
+	Failed    int       `json:"failed"`
+}
+
+// newRunRecord returns the record of an update which finished with result or err
+func newRunRecord(requestID string, result *UpdateResult, err error) *runRecord {
+	r := &runRecord{At: time.Now(), RequestID: requestID}
+	if err != nil {
+		r.Error = err.Error()
+		r.Status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			r.Status = e.Code
+		}
+		return r
+	}
+	r.Status = result.status()
+	r.Updated = len(result.Restarted)
+	r.Failed = len(result.Failed)
+	return r
+}
+
+var (
+	lastRuns   = make(map[string]*runRecord) // last update of each webhook
+	lastRunsMu sync.Mutex
+)
+
+// recordRun remembers r as the last update of the webhook name
+func recordRun(name string, r *runRecord) {
+	// name may point into a request
+	name = utils.CopyString(name)
+	lastRunsMu.Lock()
+	lastRuns[name] = r
+	lastRunsMu.Unlock()
+}
+
+// webhookStatus is a configured webhook without its secrets
+type webhookStatus struct {
+	Name      string `json:"name"`
+	Mode      string `json:"mode"`
+	Auth      bool   `json:"auth"`
+	RemoveOld bool   `json:"remove_old"`
+	Prune     bool   `json:"prune"`
+	// Matched is the amount of containers currently matched by the webhook
+	Matched    int        `json:"matched"`
+	MatchError string     `json:"match_error,omitempty"`
+	LastRun    *runRecord `json:"last_run,omitempty"`
+}
+
+// handleWebhooks lists all configured webhooks with their last update
+func handleWebhooks(ctx *fiber.Ctx) error {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]webhookStatus, 0, len(names))
+	for _, name := range names {
+		a := attrs[name]
+		s := webhookStatus{
+			Name:      name,
+			Mode:      a.mode,
+			Auth:      a.auth != "",
+			RemoveOld: a.removeOld,
+			Prune:     a.prune,
+		}
+		if matched, err := matchingContainers(ctx.Context(), name); err != nil {
+			s.MatchError = err.Error()
+		} else {
+			s.Matched = len(matched)
+		}
+		lastRunsMu.Lock()
+		s.LastRun = lastRuns[name]
+		lastRunsMu.Unlock()
+		res = append(res, s)
+	}
+	return ctx.JSON(res)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/apex/log"
+	"github.com/docker/docker/api/types"
+	"time"
+)
+
+// strategies to replace a container
+const (
+	StrategyRecreate     = "recreate"      // stop the old container, then create the new one
+	StrategyZeroDowntime = "zero-downtime" // start the new container next to the old one
+)
+
+// replacementSuffix is appended to the name of a container while its replacement runs next to it
+const replacementSuffix = "-yadwh-next"
+
+// zeroDowntimeBlocker returns why the inspected container can't run next to its replacement
+// or an empty string if it can
+func zeroDowntimeBlocker(inspect *types.ContainerJSON) string {
+	if inspect.State != nil && inspect.State.Restarting {
+		return "restarting"
+	}
+	hc := inspect.HostConfig
+	if hc.AutoRemove {
+		return "auto remove"
+	}
+	if hc.NetworkMode.IsHost() || hc.NetworkMode.IsContainer() {
+		return "network mode " + string(hc.NetworkMode)
+	}
+	for port, bindings := range hc.PortBindings {
+		for _, b := range bindings {
+			if b.HostPort != "" && b.HostPort != "0" {
+				return fmt.Sprintf("host port %s bound to %s", b.HostPort, port)
+			}
+		}
+	}
+	if inspect.NetworkSettings != nil {
+		for net, ep := range inspect.NetworkSettings.Networks {
+			if ep != nil && ep.IPAMConfig != nil && (ep.IPAMConfig.IPv4Address != "" || ep.IPAMConfig.IPv6Address != "") {
+				return "fixed IP address in network " + net
+			}
+		}
+	}
+	return ""
+}
+
+// startReplacement creates and starts the replacement of an inspected container next to it
+// and waits until it's running (and healthy, if a health timeout is set).
+// A replacement which doesn't come up is removed again
+func (a *attributes) startReplacement(dctx context.Context, inspect *types.ContainerJSON, name string) (id string, err error) {
+	tmp := name + replacementSuffix
+	if err = checkName(dctx, tmp, ""); err != nil {
+		return
+	}
+	log.Infof("Creating replacement %s with image %s", tmp, inspect.Config.Image)
+	created, err := dc.ContainerCreate(dctx, inspect.Config, inspect.HostConfig, networkingConfig(inspect), a.platformSpec(), tmp)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			discardContainer(created.ID)
+		}
+	}()
+	if err = dc.ContainerStart(dctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return
+	}
+	if a.healthTimeout > 0 {
+		if err = a.waitHealthy(dctx, created.ID); err != nil {
+			return
+		}
+	}
+	started, err := dc.ContainerInspect(dctx, created.ID)
+	if err != nil {
+		return
+	}
+	if started.State == nil || !started.State.Running {
+		return "", fmt.Errorf("replacement is not running")
+	}
+	return created.ID, nil
+}
+
+// discardContainer removes a replacement which is not needed anymore, also if the update was cancelled
+func discardContainer(id string) {
+	dctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	log.Infof("Removing replacement %s", trimID(id))
+	if err := dc.ContainerRemove(dctx, id, types.ContainerRemoveOptions{Force: true}); err != nil {
+		log.WithError(err).Warnf("Cannot remove replacement %s", trimID(id))
+	}
+}